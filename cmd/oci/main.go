@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -11,26 +12,118 @@ import (
 	"github.com/luigizuccarelli/golang-container-tools/pkg/service"
 )
 
-const (
-	apiVersion string = "/v2/"
-)
-
 var (
-	image     string
-	version   string
-	path      string
-	action    string
-	tls       string
-	basicAuth string
+	image       string
+	version     string
+	path        string
+	action      string
+	tls         string
+	basicAuth   string
+	archive     string
+	format      string
+	packages    string
+	channel     string
+	versions    string
+	platforms   string
+	config      string
+	tagRegex    string
+	tagRange    string
+	tagLatest   int
+	tagExcl     string
+	include     string
+	exclude     string
+	dryRun      bool
+	uidMap      string
+	gidMap      string
+	compression string
+	output      string
+	env         listFlag
+	labels      listFlag
+	annotations listFlag
+	ports       listFlag
+	entrypoint  string
+	cmd         string
+	user        string
+	workdir     string
+	owner       string
+	fileMode    string
+	dirMode     string
+	squash      int
+	recompress  string
+	sigPolicy   string
+	sigKeys     string
+	sigRoots    string
+	sigIdentity string
+	sigIssuer   string
+	sigRekorKey string
+	artifacts   bool
+	signingKey  string
+	signingRef  string
+	files       bool
+	perm        string
 )
 
+// listFlag - a flag that can be given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&image, "i", "", "image url : quay.io/user/component")
 	flag.StringVar(&version, "v", "", "version : v0.0.1")
 	flag.StringVar(&path, "p", "", "path to copy to: oci")
-	flag.StringVar(&action, "a", "", "copy or push")
+	flag.StringVar(&action, "a", "", "copy, push, export, import, catalog, mirror, namespace, tags, inspect, layout (ls | inspect <ref> | rm <ref> | tag <ref> <new-ref> | gc) unpack <ref> <dir>, repack <dir> <new-ref>, mutate <ref>, append <ref> <dir|tar> <new-ref>, rebase <ref> <old-base> <new-base> <new-ref>, flatten <ref> <new-ref>, diff <from> <to>, find <ref> [glob...], extract <ref> <dir> <path...> or sign <ref>")
 	flag.StringVar(&tls, "t", "true", "tls verify true (default) or false")
 	flag.StringVar(&basicAuth, "b", "false", "basic auth true or false (default)")
+	flag.StringVar(&archive, "o", "", "archive file for export or import : image.tar (.tar.gz or .tgz to compress)")
+	flag.StringVar(&format, "f", service.OCIArchive, "archive format oci-archive (default) or docker-archive")
+	flag.StringVar(&packages, "packages", "", "catalog packages to mirror (comma separated, default all)")
+	flag.StringVar(&channel, "channel", "", "catalog channel to mirror (default all)")
+	flag.StringVar(&platforms, "platforms", "", "platforms to copy from a multi-arch image (comma separated os/arch[/variant], default all)")
+	flag.StringVar(&config, "c", "", "image set config file for mirror : imageset.yaml")
+	flag.StringVar(&tagRegex, "tag-regex", "", "copy or list the tags matching the regex")
+	flag.StringVar(&tagRange, "tag-range", "", "copy or list the tags in the semver range : \">=4.10 <4.13\"")
+	flag.IntVar(&tagLatest, "tag-latest", 0, "copy or list the latest N tags by semver")
+	flag.StringVar(&tagExcl, "tag-exclude", "", "tags to exclude (comma separated regexes)")
+	flag.StringVar(&include, "include", "", "namespace repositories to include (comma separated globs : ourorg/app-*)")
+	flag.StringVar(&exclude, "exclude", "", "namespace repositories to exclude (comma separated globs)")
+	flag.BoolVar(&dryRun, "dry-run", false, "namespace only lists the repositories and tags that would be copied, layout gc only lists the blobs that would be removed")
+	flag.StringVar(&output, "output", "text", "inspect, layout, diff and find output format text (default) or json")
+	flag.BoolVar(&files, "files", false, "diff also compares the files of the images (reads every layer)")
+	flag.StringVar(&perm, "perm", "", "find only reports entries with any of the mode bits set : 4000 (setuid)")
+	flag.StringVar(&uidMap, "uid-map", "", "unpack, repack and extract uid mapping (container:host:size, comma separated)")
+	flag.StringVar(&gidMap, "gid-map", "", "unpack, repack and extract gid mapping (container:host:size, comma separated)")
+	flag.StringVar(&compression, "compression", service.CompressionGzip, "layer compression for repack, append and flatten gzip (default), zstd or none")
+	flag.StringVar(&owner, "owner", "", "append owner of the files uid[:gid] (default 0:0 for a directory, kept for a tar)")
+	flag.StringVar(&fileMode, "file-mode", "", "append permissions of the files : 0644 (default kept)")
+	flag.StringVar(&dirMode, "dir-mode", "", "append permissions of the directories : 0755 (default kept)")
+	flag.Var(&env, "env", "mutate sets an environment variable KEY=VALUE (KEY removes it), can be repeated")
+	flag.Var(&labels, "label", "mutate sets a config label KEY=VALUE (KEY removes it), can be repeated")
+	flag.Var(&annotations, "annotation", "mutate sets a manifest annotation KEY=VALUE (KEY removes it), sign adds it to the signature payload, can be repeated")
+	flag.Var(&ports, "exposed-port", "mutate exposes a port port[/tcp|udp|sctp], can be repeated")
+	flag.StringVar(&entrypoint, "entrypoint", "", "mutate sets the entrypoint : '[\"/bin/sh\",\"-c\"]' or \"/bin/sh -c\" ('' clears it)")
+	flag.StringVar(&cmd, "cmd", "", "mutate sets the cmd, the same format as entrypoint")
+	flag.StringVar(&user, "user", "", "mutate sets the user : uid[:gid] or name")
+	flag.StringVar(&workdir, "workdir", "", "mutate sets the working directory")
+	flag.StringVar(&recompress, "recompress", "", "copy and push rewrite layers with gzip, zstd or none (default kept)")
+	flag.StringVar(&sigPolicy, "sig-policy", "", "copy signature policy ignore, warn or enforce (default enforce with -sig-keys or -sig-roots, otherwise ignore)")
+	flag.StringVar(&sigKeys, "sig-keys", "", "copy verifies cosign signatures with the public keys (comma separated PEM files : cosign.pub)")
+	flag.StringVar(&sigRoots, "sig-roots", "", "copy verifies cosign signing certificates chain to the CA certificates (PEM file)")
+	flag.StringVar(&sigIdentity, "sig-identity", "", "copy only accepts signing certificates issued to the email or uri (required with -sig-roots)")
+	flag.StringVar(&sigIssuer, "sig-issuer", "", "copy only accepts signing certificates of the oidc issuer : https://accounts.google.com (required with -sig-roots)")
+	flag.StringVar(&sigRekorKey, "sig-rekor-key", "", "copy checks the transparency log entry of a signing certificate with the rekor public key (PEM file, required with -sig-roots)")
+	flag.BoolVar(&artifacts, "artifacts", false, "copy, push and mirror also copy the signatures, attestations and SBOMs of images")
+	flag.StringVar(&signingRef, "sign-reference", "", "sign names the image the signature is for : quay.io/ourorg/app (default the repository of the ref in the layout)")
+	flag.StringVar(&signingKey, "key", "", "sign private key (PEM file : cosign.key, the password of a cosign key is read from COSIGN_PASSWORD)")
+	flag.IntVar(&squash, "squash", 0, "flatten only merges the top N layers (default all)")
+	flag.StringVar(&versions, "versions", "", "catalog bundle version range : \">=1.2.0 <2.0.0\"")
 }
 
 func main() {
@@ -38,55 +131,179 @@ func main() {
 	var reg = schema.ServiceSchema{}
 
 	flag.Parse()
+	// sub commands (layout ls) may be followed by more flags
+	var args []string
+	for flag.NArg() > 0 {
+		args = append(args, flag.Arg(0))
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
 
-	if image == "" || version == "" || path == "" || action == "" {
+	query := action == "tags" || action == "inspect" || action == "layout" || action == "unpack" || action == "repack" || action == "mutate" || action == "append" || action == "rebase" || action == "flatten" || action == "diff" || action == "find" || action == "extract" || action == "sign"
+	if (path == "" && action != "mirror" && !query && !dryRun) || action == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
+	filter := schema.TagFilter{Regex: tagRegex, Range: tagRange, Latest: tagLatest}
+	if tagExcl != "" {
+		filter.Exclude = strings.Split(tagExcl, ",")
+	}
+	switch action {
+	case "copy":
+		if image == "" || (version == "" && !filter.IsSet()) {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "tags", "namespace":
+		if image == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "mirror":
+		if config == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "push", "catalog", "inspect":
+		if image == "" || version == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "layout":
+		arity := map[string]int{"ls": 1, "gc": 1, "inspect": 2, "rm": 2, "tag": 3}
+		if path == "" || len(args) == 0 || arity[args[0]] != len(args) {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "unpack", "repack", "flatten":
+		if path == "" || len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "mutate", "sign":
+		if path == "" || len(args) != 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "append":
+		if path == "" || len(args) != 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "rebase":
+		if path == "" || len(args) != 4 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "find":
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "extract":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "diff":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	case "export", "import":
+		if archive == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
 
 	// set up the struct for the service to use
-	reg.Image = image
-	reg.Version = version
-	tmp := strings.Split(image, "/")
-	if len(tmp) == 2 {
-		reg.Name = tmp[0]
-		reg.User = ""
-		reg.Component = tmp[1]
-	} else {
-		reg.Name = tmp[0]
-		reg.User = tmp[1] + "/"
-		reg.Component = tmp[2]
-	}
-	reg.Path = path
-	val, err := strconv.ParseBool(tls)
+	tlsVerify, err := strconv.ParseBool(tls)
 	if err != nil {
 		fmt.Println(fmt.Sprintf("ERROR: %v", err))
 		os.Exit(1)
 	}
-	reg.TLS = val
-	if !reg.TLS {
-		reg.URL = "http://" + reg.Name + apiVersion + reg.User + reg.Component
-	} else {
-		reg.URL = "https://" + reg.Name + apiVersion + reg.User + reg.Component
+	auth, err := strconv.ParseBool(basicAuth)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("ERROR: %v", err))
+		os.Exit(1)
 	}
-
-	val, err = strconv.ParseBool(basicAuth)
+	reg = schema.ServiceSchema{Image: image, Version: version, Path: path, TLS: tlsVerify, Auth: auth}
+	if action == "namespace" {
+		reg, err = service.NewNamespaceSchema(image, path, tlsVerify, auth)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		reg.Version = version
+	} else if image != "" {
+		reg, err = service.NewServiceSchema(image, version, path, tlsVerify, auth)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	}
+	reg.Archive = archive
+	reg.Format = format
+	if packages != "" {
+		reg.Packages = strings.Split(packages, ",")
+	}
+	reg.Channel = channel
+	reg.Versions = versions
+	if platforms != "" {
+		reg.Platforms = strings.Split(platforms, ",")
+	}
+	reg.Config = config
+	reg.Tags = filter
+	if include != "" {
+		reg.Include = strings.Split(include, ",")
+	}
+	if exclude != "" {
+		reg.Exclude = strings.Split(exclude, ",")
+	}
+	reg.DryRun = dryRun
+	reg.UIDMap = uidMap
+	reg.GIDMap = gidMap
+	reg.Compression = compression
+	reg.Owner = owner
+	reg.FileMode = fileMode
+	reg.DirMode = dirMode
+	reg.Squash = squash
+	reg.Recompress = recompress
+	reg.Signatures = schema.SignaturePolicy{Mode: sigPolicy, Roots: sigRoots, Identity: sigIdentity, Issuer: sigIssuer, RekorKey: sigRekorKey}
+	if sigKeys != "" {
+		reg.Signatures.Keys = strings.Split(sigKeys, ",")
+	}
+	reg.Artifacts = artifacts
+	reg.SigningKey = signingKey
+	reg.SigningReference = signingRef
+	reg.Files = files
+	reg.Perm = perm
+	reg.Edits, err = configEdits()
 	if err != nil {
 		fmt.Println(fmt.Sprintf("ERROR: %v", err))
 		os.Exit(1)
 	}
-	reg.Auth = val
 
-	fmt.Println("INFO: Executing OCI")
-	fmt.Println("      Action     : ", action)
-	fmt.Println("      Registry   : ", reg.Name)
-	fmt.Println("      User       : ", reg.User)
-	fmt.Println("      Version    : ", reg.Version)
-	fmt.Println("      Path       : ", reg.Path)
-	fmt.Println("      URL        : ", reg.URL)
-	fmt.Println("      TLS        : ", reg.TLS)
-	fmt.Println("      Basic Auth : ", reg.Auth)
-	fmt.Println("")
+	// query actions only print their result
+	if !query {
+		fmt.Println("INFO: Executing OCI")
+		fmt.Println("      Action     : ", action)
+		fmt.Println("      Registry   : ", reg.Name)
+		fmt.Println("      User       : ", reg.User)
+		fmt.Println("      Version    : ", reg.Version)
+		fmt.Println("      Path       : ", reg.Path)
+		fmt.Println("      URL        : ", reg.URL)
+		fmt.Println("      TLS        : ", reg.TLS)
+		fmt.Println("      Basic Auth : ", reg.Auth)
+		if reg.Config != "" {
+			fmt.Println("      Config     : ", reg.Config)
+		}
+		if reg.Archive != "" {
+			fmt.Println("      Archive    : ", reg.Archive)
+			fmt.Println("      Format     : ", reg.Format)
+		}
+		fmt.Println("")
+	}
 
 	switch action {
 	case "copy":
@@ -103,9 +320,198 @@ func main() {
 			os.Exit(1)
 		}
 		fmt.Println("INFO: OCI push completed successfully")
+	case "export":
+		err := service.OCIExportArchive(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI export completed successfully")
+	case "import":
+		err := service.OCIImportArchive(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI import completed successfully")
+	case "catalog":
+		err := service.OCICopyCatalog(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI catalog copy completed successfully")
+	case "tags":
+		tags, err := service.OCIListTags(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		for _, tag := range tags {
+			fmt.Println(tag)
+		}
+	case "inspect":
+		ii, err := service.OCIInspect(reg)
+		if err == nil {
+			err = service.PrintInspect(os.Stdout, ii, output)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "layout":
+		var err error
+		switch args[0] {
+		case "gc":
+			err = service.OCILayoutGC(reg)
+		case "rm":
+			err = service.OCILayoutRemove(reg, args[1])
+		case "tag":
+			err = service.OCILayoutTag(reg, args[1], args[2])
+		case "inspect":
+			var ii schema.ImageInspect
+			ii, err = service.OCILayoutInspect(reg, args[1])
+			if err == nil {
+				err = service.PrintInspect(os.Stdout, ii, output)
+			}
+		default:
+			var summary schema.LayoutSummary
+			summary, err = service.OCILayoutList(reg)
+			if err == nil {
+				err = service.PrintLayoutSummary(os.Stdout, summary, output)
+			}
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "unpack":
+		err := service.OCIUnpack(reg, args[0], args[1])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI unpack completed successfully")
+	case "repack":
+		err := service.OCIRepack(reg, args[0], args[1])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI repack completed successfully")
+	case "mutate":
+		err := service.OCIMutate(reg, args[0])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI mutate completed successfully")
+	case "append":
+		err := service.OCIAppend(reg, args[0], args[1], args[2])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI append completed successfully")
+	case "rebase":
+		err := service.OCIRebase(reg, args[0], args[1], args[2], args[3])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI rebase completed successfully")
+	case "flatten":
+		err := service.OCIFlatten(reg, args[0], args[1])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI flatten completed successfully")
+	case "diff":
+		diff, err := service.OCIDiff(reg, args[0], args[1])
+		if err == nil {
+			err = service.PrintDiff(os.Stdout, diff, output)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "find":
+		matches, err := service.OCIFind(reg, args[0], args[1:])
+		if err == nil {
+			err = service.PrintFind(os.Stdout, matches, output)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "sign":
+		err := service.OCISign(reg, args[0])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "extract":
+		err := service.OCIExtract(reg, args[0], args[1], args[2:])
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+	case "namespace":
+		err := service.OCIMirrorNamespace(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI namespace copy completed successfully")
+	case "mirror":
+		err := service.OCIMirrorImageSet(reg)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %v", err))
+			os.Exit(1)
+		}
+		fmt.Println("INFO: OCI mirror completed successfully")
 	default:
 		fmt.Println("ERROR: action argument not recognized")
 		os.Exit(1)
 	}
 	os.Exit(0)
 }
+
+// configEdits - the mutate flags, only the flags given on the command line change the config
+func configEdits() (schema.ConfigEdits, error) {
+	edits := schema.ConfigEdits{Env: env, Labels: labels, Annotations: annotations, ExposedPorts: ports}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "entrypoint":
+			if edits.Entrypoint, err = parseCommand(entrypoint); err != nil {
+				err = fmt.Errorf("entrypoint %v", err)
+			}
+		case "cmd":
+			if edits.Cmd, err = parseCommand(cmd); err != nil {
+				err = fmt.Errorf("cmd %v", err)
+			}
+		case "user":
+			edits.User = &user
+		case "workdir":
+			edits.WorkingDir = &workdir
+		}
+	})
+	return edits, err
+}
+
+// parseCommand - a json array (exec form) or words separated by spaces, an empty command is an empty (not nil) list
+func parseCommand(s string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(s), &list); err != nil {
+			return nil, err
+		}
+		if list == nil {
+			list = []string{}
+		}
+		return list, nil
+	}
+	return append([]string{}, strings.Fields(s)...), nil
+}
@@ -2,16 +2,23 @@ module github.com/luigizuccarelli/golang-container-tools
 
 go 1.18
 
-require github.com/google/go-containerregistry v0.11.0
+require (
+	github.com/google/go-containerregistry v0.11.0
+	github.com/klauspost/compress v1.15.8
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/docker/cli v20.10.17+incompatible // indirect
 	github.com/docker/distribution v2.8.1+incompatible // indirect
 	github.com/docker/docker v20.10.17+incompatible // indirect
 	github.com/docker/docker-credential-helpers v0.6.4 // indirect
+	github.com/kr/pretty v0.2.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
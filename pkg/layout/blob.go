@@ -0,0 +1,92 @@
+package layout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// WriteBlob - streams a blob to a temp file next to its final path, verifies the digest (and size when known)
+// then renames it into place, blobs are content addressed so concurrent writers of the same blob are harmless
+func (l *Layout) WriteBlob(d schema.Descriptor, r io.Reader) (int64, error) {
+	if err := schema.ValidateDigest(d.Digest); err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(d.Digest, "sha256:") {
+		return 0, fmt.Errorf("unsupported digest algorithm %s", d.Digest)
+	}
+	dir := filepath.Dir(l.BlobPath(d.Digest))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	if d.Size >= 0 && n != d.Size {
+		return n, fmt.Errorf("size mismatch for %s: expected %d got %d", d.Digest, d.Size, n)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != d.Digest {
+		return n, fmt.Errorf("digest mismatch: expected %s got %s", d.Digest, got)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), l.BlobPath(d.Digest))
+}
+
+// CreateBlob - streams the content fn writes to a temp file of the blobs directory and renames it into place by its digest
+// for new content (layers being compressed) whose digest is only known once it is written, nothing is staged outside the layout
+func (l *Layout) CreateBlob(fn func(w io.Writer) error) (schema.Descriptor, error) {
+	dir := filepath.Join(l.Path, BlobsDir, "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return schema.Descriptor{}, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(tmp, h)}
+	err = fn(cw)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	d := schema.Descriptor{Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: cw.n}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return d, err
+	}
+	return d, os.Rename(tmp.Name(), l.BlobPath(d.Digest))
+}
+
+// countingWriter - counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
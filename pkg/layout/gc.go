@@ -0,0 +1,227 @@
+package layout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// manifests bigger than this are not expected, larger blobs are not read when looking for referrers
+const maxManifestSize int64 = 4 << 20
+
+// GC - deletes the blobs not reachable from index.json (dryRun only reports them) and returns them with the bytes reclaimed
+// the exclusive layout lock is held so blobs of copies still in progress are never removed
+func (l *Layout) GC(dryRun bool) ([]string, int64, error) {
+	lock := l.Lock
+	if dryRun {
+		lock = l.RLock
+	}
+	release, err := lock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
+	unreferenced, err := l.Unreferenced()
+	if err != nil {
+		return nil, 0, fmt.Errorf("not collecting garbage: %v", err)
+	}
+	// left behind by interrupted writers, nobody else can be writing while we hold the lock
+	if !dryRun {
+		temps, _ := filepath.Glob(filepath.Join(l.Path, BlobsDir, "*", ".tmp-*"))
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}
+	var reclaimed int64
+	for _, digest := range unreferenced {
+		fi, err := os.Stat(l.BlobPath(digest))
+		if err != nil {
+			return nil, reclaimed, err
+		}
+		if !dryRun {
+			if err := os.Remove(l.BlobPath(digest)); err != nil {
+				return nil, reclaimed, err
+			}
+		}
+		reclaimed += fi.Size()
+	}
+	return unreferenced, reclaimed, nil
+}
+
+// reachable - digests reachable from index.json through nested indexes, manifests and their subjects,
+// referrers stored only as blobs (manifests whose subject is reachable) are kept too
+// blobs of other media types (artifacts may use any) are descended into when their content is a manifest or index
+func (l *Layout) reachable() (map[string]bool, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, err
+	}
+	marked := map[string]bool{}
+	var mark func(d schema.Descriptor) error
+	mark = func(d schema.Descriptor) error {
+		if marked[d.Digest] {
+			return nil
+		}
+		marked[d.Digest] = true
+		known := false
+		switch d.MediaType {
+		case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList,
+			schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+			known = true
+		}
+		if !known && !l.mayBeManifest(d) {
+			return nil
+		}
+		data, err := l.ReadBlob(d.Digest)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !known && !isManifest(data) {
+			return nil
+		}
+		var m struct {
+			Manifests []schema.Descriptor `json:"manifests"`
+			Config    *schema.Descriptor  `json:"config"`
+			Layers    []schema.Descriptor `json:"layers"`
+			Subject   *schema.Descriptor  `json:"subject"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("%s: %v", d.Digest, err)
+		}
+		children := append(m.Manifests, m.Layers...)
+		if m.Config != nil {
+			children = append(children, *m.Config)
+		}
+		if m.Subject != nil {
+			children = append(children, *m.Subject)
+		}
+		for _, child := range children {
+			if err := mark(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, m := range index.Manifests {
+		if err := mark(m); err != nil {
+			return nil, err
+		}
+	}
+
+	referrers, err := l.referrers()
+	if err != nil {
+		return nil, err
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range referrers {
+			if !marked[r.Digest] && marked[r.Subject.Digest] {
+				if err := mark(r.Descriptor); err != nil {
+					return nil, err
+				}
+				changed = true
+			}
+		}
+	}
+	return marked, nil
+}
+
+// mayBeManifest - false for blobs that can't be a manifest (layers, configs, large or not json) so they are not read
+func (l *Layout) mayBeManifest(d schema.Descriptor) bool {
+	if strings.Contains(d.MediaType, ".layer.") || strings.Contains(d.MediaType, ".rootfs.") ||
+		d.MediaType == schema.MediaTypeImageConfig || d.MediaType == schema.MediaTypeDockerConfig {
+		return false
+	}
+	fi, err := os.Stat(l.BlobPath(d.Digest))
+	if err != nil || fi.Size() > maxManifestSize {
+		return false
+	}
+	f, err := os.Open(l.BlobPath(d.Digest))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 1)
+	n, _ := f.Read(head)
+	return n == 1 && head[0] == '{'
+}
+
+// isManifest - true if data is an index, or a manifest with a config (an image config has a config object too, without a digest)
+func isManifest(data []byte) bool {
+	switch schema.DetectMediaType(data) {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		return true
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		var m struct {
+			Config *schema.Descriptor `json:"config"`
+		}
+		return json.Unmarshal(data, &m) == nil && m.Config != nil && m.Config.Digest != ""
+	}
+	return false
+}
+
+type referrer struct {
+	schema.Descriptor
+	Subject schema.Descriptor
+}
+
+// referrers - every manifest blob in the layout that has a subject
+func (l *Layout) referrers() ([]referrer, error) {
+	all, err := l.Blobs()
+	if err != nil {
+		return nil, err
+	}
+	var referrers []referrer
+	for _, digest := range all {
+		fi, err := os.Stat(l.BlobPath(digest))
+		if err != nil || fi.Size() > maxManifestSize {
+			continue
+		}
+		f, err := os.Open(l.BlobPath(digest))
+		if err != nil {
+			return nil, err
+		}
+		// cheap check before reading layers fully
+		head := make([]byte, 1)
+		n, _ := f.Read(head)
+		f.Close()
+		if n == 0 || head[0] != '{' {
+			continue
+		}
+		data, err := ioutil.ReadFile(l.BlobPath(digest))
+		if err != nil {
+			return nil, err
+		}
+		mediaType := schema.DetectMediaType(data)
+		if !strings.Contains(mediaType, "manifest") && mediaType != schema.MediaTypeImageIndex {
+			continue
+		}
+		var m struct {
+			ArtifactType string             `json:"artifactType"`
+			Config       *schema.Descriptor `json:"config"`
+			Subject      *schema.Descriptor `json:"subject"`
+			Annotations  map[string]string  `json:"annotations"`
+		}
+		if json.Unmarshal(data, &m) != nil || m.Subject == nil {
+			continue
+		}
+		// the artifact type of a manifest without one is the media type of its config
+		if m.ArtifactType == "" && m.Config != nil {
+			m.ArtifactType = m.Config.MediaType
+		}
+		referrers = append(referrers, referrer{
+			Descriptor: schema.Descriptor{MediaType: mediaType, Digest: digest, Size: fi.Size(), ArtifactType: m.ArtifactType, Annotations: m.Annotations},
+			Subject:    *m.Subject,
+		})
+	}
+	return referrers, nil
+}
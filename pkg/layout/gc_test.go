@@ -0,0 +1,108 @@
+package layout
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// blob - writes data to the layout as a blob of mediaType
+func blob(t *testing.T, l *Layout, mediaType string, data []byte) schema.Descriptor {
+	t.Helper()
+	d := schema.Descriptor{MediaType: mediaType, Digest: schema.Digest(data), Size: int64(len(data))}
+	if _, err := l.WriteBlob(d, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// jsonBlob - writes v as a json blob of mediaType
+func jsonBlob(t *testing.T, l *Layout, mediaType string, v interface{}) schema.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob(t, l, mediaType, data)
+}
+
+func TestReachable(t *testing.T) {
+	l := New(t.TempDir())
+	// an image config has a config object too, it must not be taken for a manifest
+	config := jsonBlob(t, l, schema.MediaTypeImageConfig, map[string]interface{}{
+		"architecture": "amd64", "os": "linux", "config": map[string]interface{}{"Env": []string{"PATH=/bin"}},
+		"rootfs": map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+	})
+	layer := blob(t, l, schema.MediaTypeImageLayerGzip, []byte("layer"))
+	image := jsonBlob(t, l, schema.MediaTypeImageManifest, schema.ImageManifest{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Config: config, Layers: []schema.Descriptor{layer},
+	})
+
+	// an artifact manifest referenced with a media type gc does not know
+	artifactConfig := blob(t, l, schema.MediaTypeEmptyJSON, []byte("{}"))
+	artifactLayer := blob(t, l, "application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	artifact := jsonBlob(t, l, "application/vnd.example.artifact+json", schema.ImageManifest{
+		SchemaVersion: 2, Config: artifactConfig, Layers: []schema.Descriptor{artifactLayer},
+	})
+	index := jsonBlob(t, l, schema.MediaTypeImageIndex, schema.ImageIndex{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex, Manifests: []schema.Descriptor{image, artifact},
+	})
+
+	// a referrer of the image stored only as a blob, and one of an image that is gone
+	sigLayer := blob(t, l, "application/vnd.dev.cosign.simplesigning.v1+json", []byte(`{"critical":{}}`))
+	referrer := jsonBlob(t, l, schema.MediaTypeImageManifest, schema.ImageManifest{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Config: artifactConfig,
+		Layers: []schema.Descriptor{sigLayer}, Subject: &image,
+	})
+	orphanLayer := blob(t, l, schema.MediaTypeImageLayerGzip, []byte("orphan"))
+	orphan := jsonBlob(t, l, schema.MediaTypeImageManifest, schema.ImageManifest{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Config: artifactConfig,
+		Layers: []schema.Descriptor{orphanLayer}, Subject: &schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: schema.Digest([]byte("gone")), Size: 4},
+	})
+
+	ref := index
+	ref.Annotations = map[string]string{schema.AnnotationRefName: "example.com/app:v1"}
+	if err := l.WriteIndex(schema.ImageIndex{SchemaVersion: 2, Manifests: []schema.Descriptor{ref}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reachable, err := l.reachable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		d    schema.Descriptor
+		want bool
+	}{
+		{"index", index, true},
+		{"image", image, true},
+		{"config", config, true},
+		{"layer", layer, true},
+		{"artifact of unknown media type", artifact, true},
+		{"artifact config", artifactConfig, true},
+		{"artifact layer", artifactLayer, true},
+		{"referrer", referrer, true},
+		{"referrer layer", sigLayer, true},
+		{"orphan referrer", orphan, false},
+		{"orphan layer", orphanLayer, false},
+	}
+	for _, tt := range tests {
+		if reachable[tt.d.Digest] != tt.want {
+			t.Errorf("%s %s reachable = %v, want %v", tt.name, tt.d.Digest, reachable[tt.d.Digest], tt.want)
+		}
+	}
+
+	unreferenced, err := l.Unreferenced()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{orphan.Digest, orphanLayer.Digest}
+	sort.Strings(want)
+	if len(unreferenced) != 2 || unreferenced[0] != want[0] || unreferenced[1] != want[1] {
+		t.Fatalf("Unreferenced = %v, want %v", unreferenced, want)
+	}
+}
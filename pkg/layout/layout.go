@@ -0,0 +1,346 @@
+package layout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const (
+	// IndexFile - the entry point of a layout
+	IndexFile string = "index.json"
+	// LayoutFile - marks a directory as an oci layout
+	LayoutFile string = "oci-layout"
+	// BlobsDir - content addressable blobs, one directory per digest algorithm
+	BlobsDir string = "blobs"
+)
+
+// ErrNotFound - returned (wrapped) by Resolve when no ref matches
+var ErrNotFound = errors.New("not found")
+
+// Layout - an oci image layout directory (as written by this tool, skopeo or buildah)
+type Layout struct {
+	Path string
+}
+
+// New - returns the layout at path, nothing is read until needed
+func New(path string) *Layout {
+	return &Layout{Path: path}
+}
+
+// BlobPath - path to the blob for a digest
+func (l *Layout) BlobPath(digest string) string {
+	i := strings.Index(digest, ":")
+	if i < 0 {
+		return filepath.Join(l.Path, BlobsDir, "sha256", digest)
+	}
+	return filepath.Join(l.Path, BlobsDir, digest[:i], digest[i+1:])
+}
+
+// HasBlob - checks if a blob (of the expected size when known) is in the layout
+func (l *Layout) HasBlob(d schema.Descriptor) bool {
+	fi, err := os.Stat(l.BlobPath(d.Digest))
+	if err != nil {
+		return false
+	}
+	return d.Size < 0 || fi.Size() == d.Size
+}
+
+// ReadBlob - reads a blob fully
+func (l *Layout) ReadBlob(digest string) ([]byte, error) {
+	return ioutil.ReadFile(l.BlobPath(digest))
+}
+
+// Index - reads index.json (an empty index is returned if it does not exist yet)
+func (l *Layout) Index() (schema.ImageIndex, error) {
+	var index = schema.ImageIndex{SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex}
+	data, err := ioutil.ReadFile(filepath.Join(l.Path, IndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, err
+	}
+	return index, index.Validate()
+}
+
+// Resolve - finds a ref in index.json by its full name, tag (or name suffix) or digest
+func (l *Layout) Resolve(ref string) (schema.Descriptor, error) {
+	index, err := l.Index()
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	matchers := []func(d schema.Descriptor) bool{
+		func(d schema.Descriptor) bool { return d.Annotations[schema.AnnotationRefName] == ref },
+		func(d schema.Descriptor) bool {
+			name := d.Annotations[schema.AnnotationRefName]
+			return strings.HasSuffix(name, "/"+ref) || strings.HasSuffix(name, ":"+ref) || strings.HasSuffix(name, "@"+ref)
+		},
+		func(d schema.Descriptor) bool { return d.Digest == ref },
+	}
+	for _, match := range matchers {
+		var found []schema.Descriptor
+		for _, m := range index.Manifests {
+			if match(m) {
+				found = append(found, m)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		if len(found) > 1 {
+			return schema.Descriptor{}, fmt.Errorf("ref %s is ambiguous in %s", ref, IndexFile)
+		}
+	}
+	return schema.Descriptor{}, fmt.Errorf("ref %s %w in %s", ref, ErrNotFound, IndexFile)
+}
+
+// Walk - calls fn for a manifest (or index) and, recursively, every blob it references
+func (l *Layout) Walk(d schema.Descriptor, fn func(schema.Descriptor) error) error {
+	if err := fn(d); err != nil {
+		return err
+	}
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		data, err := l.ReadBlob(d.Digest)
+		if err != nil {
+			return err
+		}
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return err
+		}
+		for _, m := range index.Manifests {
+			if err := l.Walk(m, fn); err != nil {
+				return err
+			}
+		}
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		data, err := l.ReadBlob(d.Digest)
+		if err != nil {
+			return err
+		}
+		var m schema.ImageManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if err := fn(m.Config); err != nil {
+			return err
+		}
+		for _, layer := range m.Layers {
+			if err := fn(layer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveImage - returns the image manifest for a ref, selecting the platform we run on when the ref is an index
+func (l *Layout) ResolveImage(d schema.Descriptor) (schema.Descriptor, schema.ImageManifest, error) {
+	data, err := l.ReadBlob(d.Digest)
+	if err != nil {
+		return d, schema.ImageManifest{}, err
+	}
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return d, schema.ImageManifest{}, err
+		}
+		if len(index.Manifests) == 0 {
+			return d, schema.ImageManifest{}, fmt.Errorf("index %s has no manifests", d.Digest)
+		}
+		child := index.Manifests[0]
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+				child = m
+				break
+			}
+		}
+		return l.ResolveImage(child)
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		var m schema.ImageManifest
+		err := json.Unmarshal(data, &m)
+		return d, m, err
+	}
+	return d, schema.ImageManifest{}, fmt.Errorf("unsupported manifest media type %q", d.MediaType)
+}
+
+// List - summarizes every ref in index.json, flagging blobs that are missing from the layout
+func (l *Layout) List() ([]schema.RefSummary, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, err
+	}
+	var refs []schema.RefSummary
+	for _, m := range index.Manifests {
+		rs := schema.RefSummary{
+			Ref:       m.Annotations[schema.AnnotationRefName],
+			Digest:    m.Digest,
+			MediaType: m.MediaType,
+		}
+		seen := map[string]bool{}
+		l.check(m, func(d schema.Descriptor, present bool) {
+			if seen[d.Digest] {
+				return
+			}
+			seen[d.Digest] = true
+			if !present {
+				// non distributable layers are expected to be missing
+				if len(d.URLs) == 0 {
+					rs.Missing = append(rs.Missing, d.Digest)
+				}
+				return
+			}
+			rs.Size += d.Size
+		})
+		rs.Platforms, err = l.platforms(m)
+		if err != nil && len(rs.Missing) == 0 {
+			return nil, err
+		}
+		refs = append(refs, rs)
+	}
+	return refs, nil
+}
+
+// check - walks a ref like Walk but reports missing blobs instead of failing on them
+func (l *Layout) check(d schema.Descriptor, fn func(schema.Descriptor, bool)) {
+	present := l.HasBlob(d)
+	fn(d, present)
+	if !present {
+		return
+	}
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		var index schema.ImageIndex
+		if data, err := l.ReadBlob(d.Digest); err == nil && json.Unmarshal(data, &index) == nil {
+			for _, m := range index.Manifests {
+				l.check(m, fn)
+			}
+		}
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		var m schema.ImageManifest
+		if data, err := l.ReadBlob(d.Digest); err == nil && json.Unmarshal(data, &m) == nil {
+			fn(m.Config, l.HasBlob(m.Config))
+			for _, layer := range m.Layers {
+				fn(layer, l.HasBlob(layer))
+			}
+		}
+	}
+}
+
+// platforms - the platforms of a ref, from the index entries or the image config
+func (l *Layout) platforms(d schema.Descriptor) ([]string, error) {
+	data, err := l.ReadBlob(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		var platforms []string
+		for _, m := range index.Manifests {
+			if m.Platform != nil {
+				platforms = append(platforms, m.Platform.String())
+			}
+		}
+		return platforms, nil
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		var m schema.ImageManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		if m.Config.MediaType != schema.MediaTypeImageConfig && m.Config.MediaType != schema.MediaTypeDockerConfig {
+			return nil, nil
+		}
+		data, err := l.ReadBlob(m.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		var cfg schema.ImageConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+		if cfg.OS == "" {
+			return nil, nil
+		}
+		return []string{(&schema.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}).String()}, nil
+	}
+	return nil, nil
+}
+
+// Blobs - every digest stored in the layout
+func (l *Layout) Blobs() ([]string, error) {
+	var digests []string
+	algs, err := ioutil.ReadDir(filepath.Join(l.Path, BlobsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, alg := range algs {
+		if !alg.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(l.Path, BlobsDir, alg.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.Mode().IsRegular() && !strings.HasPrefix(f.Name(), ".") {
+				digests = append(digests, alg.Name()+":"+f.Name())
+			}
+		}
+	}
+	sort.Strings(digests)
+	return digests, nil
+}
+
+// Unreferenced - blobs that are not reachable from any ref in index.json
+func (l *Layout) Unreferenced() ([]string, error) {
+	reachable, err := l.reachable()
+	if err != nil {
+		return nil, err
+	}
+	all, err := l.Blobs()
+	if err != nil {
+		return nil, err
+	}
+	var unreferenced []string
+	for _, digest := range all {
+		if !reachable[digest] {
+			unreferenced = append(unreferenced, digest)
+		}
+	}
+	return unreferenced, nil
+}
+
+// Referrers - the manifests in the layout with a subject (signatures, attestations and SBOMs stored only as blobs) by subject digest
+// every blob is read, callers look up all the digests they need in the result
+func (l *Layout) Referrers() (map[string][]schema.Descriptor, error) {
+	all, err := l.referrers()
+	if err != nil {
+		return nil, err
+	}
+	referrers := map[string][]schema.Descriptor{}
+	for _, r := range all {
+		referrers[r.Subject.Digest] = append(referrers[r.Subject.Digest], r.Descriptor)
+	}
+	return referrers, nil
+}
@@ -0,0 +1,117 @@
+package layout
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// image - writes a one layer image for platform p, the layer is only written when stored is set
+func image(t *testing.T, l *Layout, p schema.Platform, stored bool) schema.Descriptor {
+	t.Helper()
+	config := jsonBlob(t, l, schema.MediaTypeImageConfig, map[string]interface{}{
+		"architecture": p.Architecture, "os": p.OS, "variant": p.Variant,
+		"rootfs": map[string]interface{}{"type": "layers", "diff_ids": []string{schema.Digest([]byte(p.Architecture))}},
+	})
+	layer := schema.Descriptor{MediaType: schema.MediaTypeImageLayerGzip, Digest: schema.Digest([]byte(p.Architecture)), Size: int64(len(p.Architecture))}
+	if stored {
+		layer = blob(t, l, schema.MediaTypeImageLayerGzip, []byte(p.Architecture))
+	}
+	return jsonBlob(t, l, schema.MediaTypeImageManifest, schema.ImageManifest{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Config: config, Layers: []schema.Descriptor{layer},
+	})
+}
+
+// named - d with the ref name annotation
+func named(d schema.Descriptor, ref string) schema.Descriptor {
+	d.Annotations = map[string]string{schema.AnnotationRefName: ref}
+	return d
+}
+
+func TestResolve(t *testing.T) {
+	l := New(t.TempDir())
+	amd := image(t, l, schema.Platform{OS: "linux", Architecture: "amd64"}, true)
+	arm := image(t, l, schema.Platform{OS: "linux", Architecture: "arm64"}, true)
+	// skopeo and buildah name refs by their tag only
+	if err := l.WriteIndex(schema.ImageIndex{SchemaVersion: 2, Manifests: []schema.Descriptor{
+		named(amd, "quay.io/ourorg/app:v1"), named(arm, "quay.io/ourorg/tool:v1"), named(arm, "v2"),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref  string
+		want string
+		err  string
+	}{
+		{"quay.io/ourorg/app:v1", amd.Digest, ""},
+		{"app:v1", amd.Digest, ""},
+		{"v2", arm.Digest, ""},
+		{amd.Digest, amd.Digest, ""},
+		{"v1", "", "is ambiguous"},
+		{arm.Digest, "", "is ambiguous"},
+		{"v3", "", "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			d, err := l.Resolve(tt.ref)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Resolve error %v, want %q", err, tt.err)
+				}
+				if tt.err == "not found" && !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Resolve error %v is not ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Digest != tt.want {
+				t.Fatalf("Resolve = %s, want %s", d.Digest, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	l := New(t.TempDir())
+	amd := image(t, l, schema.Platform{OS: "linux", Architecture: "amd64"}, true)
+	arm := image(t, l, schema.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, false)
+	amdEntry, armEntry := amd, arm
+	amdEntry.Platform = &schema.Platform{OS: "linux", Architecture: "amd64"}
+	armEntry.Platform = &schema.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	index := jsonBlob(t, l, schema.MediaTypeImageIndex, schema.ImageIndex{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex, Manifests: []schema.Descriptor{amdEntry, armEntry},
+	})
+	if err := l.WriteIndex(schema.ImageIndex{SchemaVersion: 2, Manifests: []schema.Descriptor{
+		named(amd, "quay.io/ourorg/app:v1"), named(index, "v2"),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 {
+		t.Fatalf("List has %d refs, want 2", len(refs))
+	}
+	single := refs[0]
+	if single.Ref != "quay.io/ourorg/app:v1" || single.Digest != amd.Digest || !reflect.DeepEqual(single.Platforms, []string{"linux/amd64"}) || single.Missing != nil {
+		t.Fatalf("List image = %+v", single)
+	}
+	// the arm64 layer is missing, its size is not counted
+	multi := refs[1]
+	if multi.Ref != "v2" || !reflect.DeepEqual(multi.Platforms, []string{"linux/amd64", "linux/arm64/v8"}) {
+		t.Fatalf("List index = %+v", multi)
+	}
+	if want := []string{schema.Digest([]byte("arm64"))}; !reflect.DeepEqual(multi.Missing, want) {
+		t.Fatalf("List index missing %v, want %v", multi.Missing, want)
+	}
+	if multi.Size <= single.Size {
+		t.Fatalf("index size %d is not larger than the size %d of one of its images", multi.Size, single.Size)
+	}
+}
@@ -0,0 +1,62 @@
+package layout
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// LockFile - advisory lock of the layout, shared by writers and held exclusively by gc
+	LockFile string = ".lock"
+	// IndexLockFile - advisory lock serializing updates of index.json across processes
+	IndexLockFile string = ".index.lock"
+)
+
+// Lock - takes the exclusive lock of the layout (blocks until every writer is done), call release to unlock
+func (l *Layout) Lock() (release func() error, err error) {
+	return l.lock(LockFile, true)
+}
+
+// RLock - takes the shared lock held while blobs and refs are being added, so gc cannot remove them halfway
+func (l *Layout) RLock() (release func() error, err error) {
+	return l.lock(LockFile, false)
+}
+
+// indexMutexes - serialize index.json updates of a layout within the process, file locks may not (windows)
+var indexMutexes sync.Map
+
+// lockIndex - held while index.json is read, changed and written back
+func (l *Layout) lockIndex() (func() error, error) {
+	path, err := filepath.Abs(l.Path)
+	if err != nil {
+		path = l.Path
+	}
+	mu, _ := indexMutexes.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	release, err := l.lock(IndexLockFile, true)
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+	return func() error {
+		defer mu.(*sync.Mutex).Unlock()
+		return release()
+	}, nil
+}
+
+func (l *Layout) lock(name string, exclusive bool) (func() error, error) {
+	if err := os.MkdirAll(l.Path, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(l.Path, name), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// closing the file releases the lock
+	return f.Close, nil
+}
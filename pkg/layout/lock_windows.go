@@ -0,0 +1,10 @@
+//go:build windows
+
+package layout
+
+import "os"
+
+// flock - advisory locks are not supported, only a single process should use a layout at a time (lockIndex still serializes its goroutines)
+func flock(f *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package layout
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// Remove - drops a ref (full name, tag or digest) from index.json, its blobs are left for gc
+func (l *Layout) Remove(ref string) (schema.Descriptor, error) {
+	release, err := l.lockIndex()
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	defer release()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return d, err
+	}
+	index, err := l.Index()
+	if err != nil {
+		return d, err
+	}
+	var list []schema.Descriptor
+	for _, m := range index.Manifests {
+		if m.Digest != d.Digest || m.Annotations[schema.AnnotationRefName] != d.Annotations[schema.AnnotationRefName] {
+			list = append(list, m)
+		}
+	}
+	index.Manifests = list
+	return d, l.WriteIndex(index)
+}
+
+// AddRef - adds the manifest (or index) d to index.json as ref, an existing ref with that name is replaced
+func (l *Layout) AddRef(d schema.Descriptor, ref string) error {
+	release, err := l.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return l.addRef(d, ref)
+}
+
+// UpdateRef - points ref at the manifest update returns for the one ref names now (nil if there is none)
+// the index lock is held throughout, concurrent updates of a ref (adding signatures) are applied one after the other
+func (l *Layout) UpdateRef(ref string, update func(current *schema.Descriptor) (schema.Descriptor, error)) error {
+	release, err := l.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer release()
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	var current *schema.Descriptor
+	for i, m := range index.Manifests {
+		if m.Annotations[schema.AnnotationRefName] == ref {
+			current = &index.Manifests[i]
+		}
+	}
+	d, err := update(current)
+	if err != nil {
+		return err
+	}
+	return l.addRef(d, ref)
+}
+
+// addRef - AddRef with the index lock already held
+func (l *Layout) addRef(d schema.Descriptor, ref string) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{}
+	for k, v := range d.Annotations {
+		annotations[k] = v
+	}
+	annotations[schema.AnnotationRefName] = ref
+	d.Annotations = annotations
+	var list []schema.Descriptor
+	for _, m := range index.Manifests {
+		if m.Annotations[schema.AnnotationRefName] != ref {
+			list = append(list, m)
+		}
+	}
+	index.Manifests = append(list, d)
+	return l.WriteIndex(index)
+}
+
+// Tag - names the manifest of src as newRef, an existing ref with that name is replaced
+// a newRef without a repository (v2) is a new tag in the repository of src
+func (l *Layout) Tag(src, newRef string) (schema.Descriptor, error) {
+	release, err := l.lockIndex()
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	defer release()
+	d, err := l.Resolve(src)
+	if err != nil {
+		return d, err
+	}
+	if name := d.Annotations[schema.AnnotationRefName]; name != "" && !strings.ContainsAny(newRef, "/:@") {
+		newRef = Repository(name) + ":" + newRef
+	}
+	index, err := l.Index()
+	if err != nil {
+		return d, err
+	}
+	tagged := d
+	tagged.Annotations = map[string]string{}
+	for k, v := range d.Annotations {
+		tagged.Annotations[k] = v
+	}
+	tagged.Annotations[schema.AnnotationRefName] = newRef
+	var list []schema.Descriptor
+	for _, m := range index.Manifests {
+		if m.Annotations[schema.AnnotationRefName] != newRef {
+			list = append(list, m)
+		}
+	}
+	index.Manifests = append(list, tagged)
+	return tagged, l.WriteIndex(index)
+}
+
+// WriteIndex - replaces index.json (and writes oci-layout) using a temp file and rename, readers never see a partial file
+// callers changing an existing index hold the index lock
+func (l *Layout) WriteIndex(index schema.ImageIndex) error {
+	if err := index.Validate(); err != nil {
+		return err
+	}
+	ociLayout, err := json.Marshal(schema.ImageLayout{Version: schema.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := l.writeFile(LayoutFile, ociLayout); err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return l.writeFile(IndexFile, data)
+}
+
+func (l *Layout) writeFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(l.Path, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.Path, name))
+}
+
+// Repository - the name of a ref without its tag or digest
+func Repository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
@@ -0,0 +1,176 @@
+package layout
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestAddRefConcurrent(t *testing.T) {
+	l := New(t.TempDir())
+	d := schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", 1), Size: 2}
+	const refs = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, refs)
+	for i := 0; i < refs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- l.AddRef(d, fmt.Sprintf("example.com/app:v%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := l.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != refs {
+		t.Fatalf("index.json has %d refs, want %d", len(index.Manifests), refs)
+	}
+}
+
+func TestUpdateRefConcurrent(t *testing.T) {
+	l := New(t.TempDir())
+	// every update moves the ref to the next digest, none is lost when they run at once
+	const updates = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, updates)
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- l.UpdateRef("example.com/app:v1", func(current *schema.Descriptor) (schema.Descriptor, error) {
+				n := 0
+				if current != nil {
+					n = int(current.Size)
+				}
+				return schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", n+1), Size: int64(n + 1)}, nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := l.Resolve("example.com/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Size != updates {
+		t.Fatalf("ref was updated %d times, want %d", d.Size, updates)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  string
+		want []string
+		err  string
+	}{
+		{"full name", "example.com/app:v1", []string{"example.com/app:v2", "example.com/tool:v1"}, ""},
+		{"tag", "v2", []string{"example.com/app:v1", "example.com/tool:v1"}, ""},
+		// the other ref of the same manifest is kept
+		{"one of two names", "tool:v1", []string{"example.com/app:v1", "example.com/app:v2"}, ""},
+		{"ambiguous", "v1", nil, "is ambiguous"},
+		{"unknown", "v3", nil, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(t.TempDir())
+			d1 := schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", 1), Size: 2}
+			d2 := schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", 2), Size: 2}
+			for ref, d := range map[string]schema.Descriptor{"example.com/app:v1": d1, "example.com/app:v2": d2, "example.com/tool:v1": d1} {
+				if err := l.AddRef(d, ref); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := l.Remove(tt.ref)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Remove error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := refNames(t, l); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("refs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTag(t *testing.T) {
+	d1 := schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", 1), Size: 2}
+	d2 := schema.Descriptor{MediaType: schema.MediaTypeImageManifest, Digest: "sha256:" + fmt.Sprintf("%064d", 2), Size: 2}
+	tests := []struct {
+		name   string
+		src    string
+		newRef string
+		want   string
+		digest string
+		refs   []string
+	}{
+		{"bare tag keeps the repository", "example.com/app:v1", "v1-fixed", "example.com/app:v1-fixed", d1.Digest, []string{"example.com/app:v1", "example.com/app:v1-fixed", "example.com/app:v2"}},
+		{"new repository", "v2", "registry.example.com/app:latest", "registry.example.com/app:latest", d2.Digest, []string{"example.com/app:v1", "example.com/app:v2", "registry.example.com/app:latest"}},
+		{"existing ref is replaced", "v1", "example.com/app:v2", "example.com/app:v2", d1.Digest, []string{"example.com/app:v1", "example.com/app:v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(t.TempDir())
+			for ref, d := range map[string]schema.Descriptor{"example.com/app:v1": d1, "example.com/app:v2": d2} {
+				if err := l.AddRef(d, ref); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tagged, err := l.Tag(tt.src, tt.newRef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tagged.Annotations[schema.AnnotationRefName] != tt.want {
+				t.Fatalf("Tag named %s, want %s", tagged.Annotations[schema.AnnotationRefName], tt.want)
+			}
+			d, err := l.Resolve(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Digest != tt.digest {
+				t.Fatalf("%s resolves to %s, want %s", tt.want, d.Digest, tt.digest)
+			}
+			if got := refNames(t, l); !reflect.DeepEqual(got, tt.refs) {
+				t.Fatalf("refs %v, want %v", got, tt.refs)
+			}
+		})
+	}
+}
+
+// refNames - the sorted ref names of index.json
+func refNames(t *testing.T, l *Layout) []string {
+	t.Helper()
+	index, err := l.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range index.Manifests {
+		names = append(names, m.Annotations[schema.AnnotationRefName])
+	}
+	sort.Strings(names)
+	return names
+}
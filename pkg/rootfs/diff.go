@@ -0,0 +1,236 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// Diff - writes a layer (uncompressed tar) with what changed in root since it was unpacked, deletions become whiteouts
+// ownership of unchanged paths comes from the state, so rootless unpacks can be repacked without losing it
+// returns the number of entries written (zero when nothing changed)
+func Diff(root string, state *schema.UnpackState, opts Options, w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+	var count int
+	present := map[string]bool{}
+	// type changes and deletions hide everything that was below them
+	replaced := map[string]bool{}
+	// first name written for each inode, later names become hard links
+	links := map[uint64]string{}
+	written := map[string]bool{}
+
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		present[name] = true
+		entry := state.Entries[name]
+		if entry != nil && entry.Type == tar.TypeLink {
+			// compare hard links with what they link to
+			if target, ok := state.Entries[entry.Linkname]; ok {
+				entry = target
+			}
+		}
+
+		hdr, err := header(p, name, fi, entry, opts)
+		if err != nil {
+			return err
+		}
+		if entry != nil && entry.Type == tar.TypeDir && !fi.IsDir() {
+			replaced[name] = true
+		}
+		ino, nlink := inode(fi)
+		var modified bool
+		if first, ok := links[ino]; ok && hdr.Typeflag == tar.TypeReg && nlink > 1 {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			// links to a changed file are written again, the lower layer still links the old content
+			orig := state.Entries[name]
+			modified = orig == nil || orig.Type != tar.TypeLink || orig.Linkname != first || written[first]
+		} else {
+			if hdr.Typeflag == tar.TypeReg && nlink > 1 {
+				links[ino] = name
+			}
+			if modified, err = changed(p, hdr, entry); err != nil {
+				return err
+			}
+		}
+		if !modified {
+			return nil
+		}
+		written[name] = true
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		count++
+		if hdr.Typeflag == tar.TypeReg {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	var deleted []string
+	for name, entry := range state.Entries {
+		if !present[name] && !entry.Skipped {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	whiteouts := map[string]bool{}
+	for _, name := range deleted {
+		if hidden(name, whiteouts) || hidden(name, replaced) {
+			continue
+		}
+		whiteouts[name] = true
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(path.Dir(name), whiteoutPrefix+path.Base(name)),
+			Mode:     0644,
+			ModTime:  state.Entries[name].ModTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, tw.Close()
+}
+
+// header - the tar header of a path on disk, with the ownership it has in the image
+func header(p, name string, fi os.FileInfo, entry *schema.UnpackEntry, opts Options) (*tar.Header, error) {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(p); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Format = tar.FormatPAX
+	hdr.AccessTime, hdr.ChangeTime = hdr.ModTime, hdr.ModTime
+
+	switch {
+	case opts.Rootless && len(opts.UIDMap) == 0 && len(opts.GIDMap) == 0:
+		// files on disk are all ours, new files belong to root in the image
+		hdr.Uid, hdr.Gid = 0, 0
+		if entry != nil {
+			hdr.Uid, hdr.Gid = entry.UID, entry.GID
+		}
+	default:
+		uid, gid := owner(fi)
+		if hdr.Uid, err = toContainer(opts.UIDMap, uid); err != nil {
+			return nil, err
+		}
+		if hdr.Gid, err = toContainer(opts.GIDMap, gid); err != nil {
+			return nil, err
+		}
+	}
+
+	xattrs, err := listxattrs(p)
+	if err != nil && !isPermission(err) {
+		return nil, err
+	}
+	if len(xattrs) == 0 && entry != nil {
+		// not readable (or not restored) on this host
+		xattrs = entry.Xattrs
+	}
+	for k, v := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[xattrPrefix+k] = v
+	}
+	return hdr, nil
+}
+
+// changed - compares a path on disk with what the image has for it
+func changed(p string, hdr *tar.Header, entry *schema.UnpackEntry) (bool, error) {
+	if entry == nil || typeflag(entry.Type) != hdr.Typeflag {
+		return true, nil
+	}
+	// symlink permissions are not used (and not kept by every filesystem)
+	if hdr.Typeflag != tar.TypeSymlink && hdr.Mode&07777 != entry.Mode&07777 {
+		return true, nil
+	}
+	if hdr.Uid != entry.UID || hdr.Gid != entry.GID {
+		return true, nil
+	}
+	if len(hdr.PAXRecords) != len(entry.Xattrs) {
+		return true, nil
+	}
+	for k, v := range entry.Xattrs {
+		if hdr.PAXRecords[xattrPrefix+k] != v {
+			return true, nil
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		return hdr.Linkname != entry.Linkname, nil
+	case tar.TypeReg:
+		if hdr.Size != entry.Size {
+			return true, nil
+		}
+		if hdr.ModTime.Equal(entry.ModTime) {
+			return false, nil
+		}
+		// touched, only a change if the content is different
+		f, err := os.Open(p)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return false, err
+		}
+		return "sha256:"+hex.EncodeToString(h.Sum(nil)) != entry.Digest, nil
+	}
+	return false, nil
+}
+
+// typeflag - the type as written by tar.FileInfoHeader
+func typeflag(t byte) byte {
+	if t == tar.TypeRegA {
+		return tar.TypeReg
+	}
+	return t
+}
+
+// hidden - checks if a parent directory of name is in the set
+func hidden(name string, set map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if set[dir] {
+			return true
+		}
+	}
+	return false
+}
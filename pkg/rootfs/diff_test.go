@@ -0,0 +1,101 @@
+package rootfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	base := layer(t,
+		entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"},
+		entry{"data/", ""}, entry{"data/a", "a"}, entry{"data/sub/", ""}, entry{"data/sub/b", "b"},
+	)
+	tests := []struct {
+		name   string
+		change func(root string) error
+		want   []entry
+	}{
+		{
+			name:   "nothing changed",
+			change: func(root string) error { return nil },
+		},
+		{
+			name: "file added and modified",
+			change: func(root string) error {
+				if err := ioutil.WriteFile(filepath.Join(root, "etc/hosts"), []byte("changed"), 0644); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(root, "etc/resolv.conf"), []byte("dns"), 0644)
+			},
+			want: []entry{{"etc/hosts", "changed"}, {"etc/resolv.conf", "dns"}},
+		},
+		{
+			name:   "file deleted",
+			change: func(root string) error { return os.Remove(filepath.Join(root, "etc/passwd")) },
+			want:   []entry{{"etc/.wh.passwd", ""}},
+		},
+		{
+			name:   "directory deleted is one whiteout",
+			change: func(root string) error { return os.RemoveAll(filepath.Join(root, "data")) },
+			want:   []entry{{".wh.data", ""}},
+		},
+		{
+			name: "directory replaced by a file",
+			change: func(root string) error {
+				if err := os.RemoveAll(filepath.Join(root, "data/sub")); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(root, "data/sub"), []byte("file"), 0644)
+			},
+			want: []entry{{"data/sub", "file"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, state := unpack(t, base)
+			if err := tt.change(root); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			count, err := Diff(root, state, Options{Rootless: true}, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := entries(t, buf.Bytes())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Diff = %v, want %v", got, tt.want)
+			}
+			if count != len(tt.want) {
+				t.Fatalf("Diff count = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	root, state := unpack(t, layer(t, entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"}))
+	if err := os.Remove(filepath.Join(root, "etc/hosts")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte("wheel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := Diff(root, state, Options{Rootless: true}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	diff := buf.Bytes()
+	// the diff applied on top of the original layer gives the changed root
+	again, _ := unpack(t,
+		layer(t, entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"}),
+		func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(diff)), nil },
+	)
+	if got, want := tree(t, again), tree(t, root); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tree = %v, want %v", got, want)
+	}
+}
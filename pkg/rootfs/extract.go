@@ -0,0 +1,148 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Select - writes the final state of paths (everything below the directories among them included) as one layer (uncompressed tar)
+// layers (bottom first) are read from the top and the lower ones are not opened once every path is known
+// hard links are written last so their targets are there first, returns the paths that are not in the image and the layers read
+func Select(layers []Opener, paths []string, w io.Writer) ([]string, int, error) {
+	var wanted []string
+	for _, p := range paths {
+		if name := strings.Trim(path.Clean("/"+p), "/"); name != "" {
+			wanted = append(wanted, name)
+		}
+	}
+	if len(wanted) == 0 {
+		return nil, 0, fmt.Errorf("no paths to select")
+	}
+	selected := func(name string) bool {
+		for _, want := range wanted {
+			if name == want || strings.HasPrefix(name, want+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	tw := tar.NewWriter(w)
+	seen := map[string]bool{}
+	deleted := map[string]bool{}
+	opaque := map[string]bool{}
+	// wanted paths that are in the image, and those no lower layer can change anymore
+	found := map[string]bool{}
+	done := map[string]bool{}
+	var links []*tar.Header
+	var read int
+	for i := len(layers) - 1; i >= 0 && len(done) < len(wanted); i-- {
+		r, err := layers[i]()
+		if err != nil {
+			return nil, read, err
+		}
+		read++
+		layerDeleted := map[string]bool{}
+		layerOpaque := map[string]bool{}
+		err = func() (err error) {
+			defer func() {
+				if cerr := r.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+				if name == "" {
+					continue
+				}
+				dir, base := path.Split(name)
+				dir = strings.TrimSuffix(dir, "/")
+				switch {
+				case base == whiteoutOpaque:
+					if !isHidden(dir, deleted, opaque) {
+						layerOpaque[dir] = true
+					}
+					continue
+				case strings.HasPrefix(base, whiteoutPrefix):
+					if target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)); !isHidden(target, deleted, opaque) {
+						layerDeleted[target] = true
+					}
+					continue
+				}
+				if seen[name] || isHidden(name, deleted, opaque) {
+					continue
+				}
+				seen[name] = true
+				if hdr.Typeflag != tar.TypeDir {
+					layerDeleted[name] = true
+				}
+				if !selected(name) {
+					continue
+				}
+				for _, want := range wanted {
+					// a directory is in the image when anything below it is, a layer may only make it opaque
+					if name == want || strings.HasPrefix(name, want+"/") {
+						found[want] = true
+					}
+				}
+				hdr.Name = name
+				if hdr.Typeflag == tar.TypeDir {
+					hdr.Name += "/"
+				}
+				if hdr.Typeflag == tar.TypeLink {
+					hdr.Linkname = strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+					links = append(links, hdr)
+					continue
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					return err
+				}
+			}
+		}()
+		if err != nil {
+			return nil, read, err
+		}
+		for name := range layerDeleted {
+			deleted[name] = true
+		}
+		for name := range layerOpaque {
+			opaque[name] = true
+		}
+		for _, want := range wanted {
+			// a file (or anything but a directory) is final once found, a directory once lower layers are hidden and its own entry is read
+			if isHidden(want, deleted, opaque) || (opaque[want] && seen[want]) {
+				done[want] = true
+			}
+		}
+	}
+
+	for _, hdr := range links {
+		if !seen[hdr.Linkname] || !selected(hdr.Linkname) {
+			return nil, read, fmt.Errorf("/%s is a hard link to /%s, add it to the paths", strings.TrimSuffix(hdr.Name, "/"), hdr.Linkname)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, read, err
+		}
+	}
+	var missing []string
+	for _, want := range wanted {
+		if !found[want] {
+			missing = append(missing, "/"+want)
+		}
+	}
+	return missing, read, tw.Close()
+}
@@ -0,0 +1,92 @@
+package rootfs
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	layers := []Opener{
+		layer(t, entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"},
+			entry{"data/", ""}, entry{"data/a", "a"}, entry{"bin/", ""}, entry{"bin/busybox", "elf"}),
+		layer(t, entry{"etc/.wh.passwd", ""}, entry{"data/.wh..wh..opq", ""}, entry{"data/b", "b"}),
+		layer(t, entry{"etc/hosts", "new"}, entry{"bin/sh=>bin/busybox", ""}),
+	}
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []entry
+		missing []string
+		read    int
+		err     string
+	}{
+		{
+			name:  "file of the top layer stops reading",
+			paths: []string{"/etc/hosts"},
+			want:  []entry{{"etc/hosts", "new"}},
+			read:  1,
+		},
+		{
+			name:    "deleted file is missing",
+			paths:   []string{"/etc/passwd"},
+			missing: []string{"/etc/passwd"},
+			read:    2,
+		},
+		{
+			name:  "opaque directory hides lower content",
+			paths: []string{"/data"},
+			want:  []entry{{"data/b", "b"}, {"data/", ""}},
+			read:  3,
+		},
+		{
+			name:  "directory with everything below it",
+			paths: []string{"etc"},
+			want:  []entry{{"etc/hosts", "new"}, {"etc/", ""}},
+			read:  3,
+		},
+		{
+			name:  "hard link written after its target",
+			paths: []string{"/bin"},
+			want:  []entry{{"bin/", ""}, {"bin/busybox", "elf"}, {"bin/sh=>bin/busybox", ""}},
+			read:  3,
+		},
+		{
+			name:  "hard link without its target",
+			paths: []string{"/bin/sh"},
+			err:   "add it to the paths",
+		},
+		{
+			name:    "not in the image",
+			paths:   []string{"/usr/bin/env", "/etc/hosts"},
+			want:    []entry{{"etc/hosts", "new"}},
+			missing: []string{"/usr/bin/env"},
+			read:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			missing, read, err := Select(layers, tt.paths, &buf)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Select error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entries(t, buf.Bytes()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Select = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Fatalf("missing = %v, want %v", missing, tt.missing)
+			}
+			if read != tt.read {
+				t.Fatalf("read %d layers, want %d", read, tt.read)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// Files - the paths of the filesystem the layers (bottom first) make, read from the layer tars without writing anything to disk
+// regular files get the digest of their content, hard links the size and digest of their target
+func Files(layers []Opener) (map[string]*schema.UnpackEntry, error) {
+	files := map[string]*schema.UnpackEntry{}
+	for _, open := range layers {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		err = applyFiles(files, r)
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// applyFiles - applies one layer to files, whiteouts only remove what lower layers added
+func applyFiles(files map[string]*schema.UnpackEntry, r io.Reader) error {
+	added := map[string]*schema.UnpackEntry{}
+	var order []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == whiteoutOpaque:
+			removeBelow(files, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(files, target)
+			removeBelow(files, target)
+			continue
+		}
+
+		entry := &schema.UnpackEntry{
+			Type:     typeflag(hdr.Typeflag),
+			Mode:     hdr.Mode,
+			UID:      hdr.Uid,
+			GID:      hdr.Gid,
+			ModTime:  hdr.ModTime,
+			Linkname: hdr.Linkname,
+			Devmajor: hdr.Devmajor,
+			Devminor: hdr.Devminor,
+		}
+		for k, v := range hdr.PAXRecords {
+			if strings.HasPrefix(k, xattrPrefix) {
+				if entry.Xattrs == nil {
+					entry.Xattrs = map[string]string{}
+				}
+				entry.Xattrs[strings.TrimPrefix(k, xattrPrefix)] = v
+			}
+		}
+		switch entry.Type {
+		case tar.TypeReg:
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return err
+			}
+			entry.Size = n
+			entry.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+		case tar.TypeLink:
+			entry.Linkname = strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+			target, ok := added[entry.Linkname]
+			if !ok {
+				target = files[entry.Linkname]
+			}
+			if target != nil {
+				entry.Size, entry.Digest = target.Size, target.Digest
+			}
+		}
+		if _, ok := added[name]; !ok {
+			order = append(order, name)
+		}
+		added[name] = entry
+	}
+	for _, name := range order {
+		entry := added[name]
+		if old, ok := files[name]; ok && old.Type == tar.TypeDir && entry.Type != tar.TypeDir {
+			removeBelow(files, name)
+		}
+		files[name] = entry
+	}
+	return nil
+}
+
+// removeBelow - removes every path inside dir
+func removeBelow(files map[string]*schema.UnpackEntry, dir string) {
+	prefix := dir + "/"
+	for name := range files {
+		if dir == "" || strings.HasPrefix(name, prefix) {
+			delete(files, name)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestFiles(t *testing.T) {
+	base := layer(t,
+		entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"},
+		entry{"data/", ""}, entry{"data/a", "a"}, entry{"data/sub/", ""}, entry{"data/sub/b", "b"},
+	)
+	tests := []struct {
+		name   string
+		layers []Opener
+		want   []string
+	}{
+		{"base", []Opener{base}, []string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/hosts", "etc/passwd"}},
+		{"whiteout file", []Opener{base, layer(t, entry{"etc/.wh.hosts", ""})}, []string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/passwd"}},
+		{"whiteout directory", []Opener{base, layer(t, entry{".wh.data", ""})}, []string{"etc", "etc/hosts", "etc/passwd"}},
+		{
+			"opaque keeps what the layer adds, in any order",
+			[]Opener{base, layer(t, entry{"data/c", "c"}, entry{"data/.wh..wh..opq", ""})},
+			[]string{"data", "data/c", "etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			"directory replaced by a file",
+			[]Opener{base, layer(t, entry{"data", "file"})},
+			[]string{"data", "etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			"whiteout then added again",
+			[]Opener{base, layer(t, entry{"etc/.wh.hosts", ""}), layer(t, entry{"etc/hosts", "new"})},
+			[]string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/hosts", "etc/passwd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := Files(tt.layers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for name := range files {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesEntries(t *testing.T) {
+	files, err := Files([]Opener{
+		layer(t, entry{"bin/", ""}, entry{"bin/busybox", "elf"}),
+		layer(t, entry{"bin/sh=>bin/busybox", ""}, entry{"bin/busybox", "elf2"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := files["bin/busybox"]; e.Type != tar.TypeReg || e.Size != 4 || e.Digest != schema.Digest([]byte("elf2")) {
+		t.Fatalf("bin/busybox = %+v, want the file of the upper layer", e)
+	}
+	// the link comes before the file is replaced in the layer, it keeps the old content
+	if e := files["bin/sh"]; e.Type != tar.TypeLink || e.Linkname != "bin/busybox" || e.Digest != schema.Digest([]byte("elf")) {
+		t.Fatalf("bin/sh = %+v, want a hard link with the digest of its target", e)
+	}
+}
@@ -0,0 +1,118 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// Find - the entries of the layers (bottom first) that match, whiteouts are matched against the path they delete
+// matches are marked hidden when a higher layer replaces or deletes them, only the layers are read (nothing is written to disk)
+func Find(layers []Opener, match func(name string, hdr *tar.Header) bool) ([]schema.FileMatch, error) {
+	var matches []schema.FileMatch
+	// what each layer has, deletes and makes opaque, to know which matches are hidden by a higher layer
+	entries := make([]map[string]bool, len(layers))
+	deleted := make([]map[string]bool, len(layers))
+	opaque := make([]map[string]bool, len(layers))
+	for i, open := range layers {
+		entries[i], deleted[i], opaque[i] = map[string]bool{}, map[string]bool{}, map[string]bool{}
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		err = func() (err error) {
+			defer func() {
+				if cerr := r.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+				if name == "" {
+					continue
+				}
+				dir, base := path.Split(name)
+				dir = strings.TrimSuffix(dir, "/")
+				whiteout := base == whiteoutOpaque || strings.HasPrefix(base, whiteoutPrefix)
+				switch {
+				case base == whiteoutOpaque:
+					opaque[i][dir] = true
+					name = dir
+				case whiteout:
+					name = path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+					deleted[i][name] = true
+				default:
+					entries[i][name] = hdr.Typeflag != tar.TypeDir
+				}
+				if name == "" || !match(name, hdr) {
+					continue
+				}
+				kind := typeName(hdr.Typeflag)
+				if base == whiteoutOpaque {
+					kind = "opaque"
+				} else if whiteout {
+					kind = "whiteout"
+				}
+				matches = append(matches, schema.FileMatch{
+					Path:       "/" + name,
+					LayerIndex: i,
+					Type:       kind,
+					Mode:       hdr.Mode,
+					UID:        hdr.Uid,
+					GID:        hdr.Gid,
+					Size:       hdr.Size,
+					Linkname:   hdr.Linkname,
+					Whiteout:   whiteout,
+				})
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for m := range matches {
+		name := strings.TrimPrefix(matches[m].Path, "/")
+		for j := matches[m].LayerIndex + 1; j < len(layers) && !matches[m].Hidden; j++ {
+			if _, ok := entries[j][name]; ok || deleted[j][name] {
+				matches[m].Hidden = true
+			}
+			for dir := path.Dir(name); dir != "." && !matches[m].Hidden; dir = path.Dir(dir) {
+				// replaced by something that is not a directory, deleted or made opaque
+				matches[m].Hidden = entries[j][dir] || deleted[j][dir] || opaque[j][dir]
+			}
+		}
+	}
+	return matches, nil
+}
+
+// typeName - a readable name for a tar entry type
+func typeName(t byte) string {
+	switch typeflag(t) {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return string(t)
+}
@@ -0,0 +1,78 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// found - a match in the notation path@layer, with (hidden) and the type when it is a whiteout
+func found(path string, layer int, kind string, hidden bool) string {
+	s := path + "@" + string(rune('0'+layer))
+	if kind == "whiteout" || kind == "opaque" {
+		s += " " + kind
+	}
+	if hidden {
+		s += " (hidden)"
+	}
+	return s
+}
+
+func TestFind(t *testing.T) {
+	layers := []Opener{
+		layer(t, entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"},
+			entry{"data/", ""}, entry{"data/a", "a"}, entry{"opt/", ""}, entry{"opt/tool", "x"}),
+		layer(t, entry{"etc/hosts", "new"}, entry{"etc/.wh.passwd", ""}, entry{"data/.wh..wh..opq", ""}, entry{"data/b", "b"}),
+		layer(t, entry{"opt", "not a directory"}),
+	}
+	tests := []struct {
+		name  string
+		match func(name string, hdr *tar.Header) bool
+		want  []string
+	}{
+		{
+			"replaced file",
+			func(name string, hdr *tar.Header) bool { return name == "etc/hosts" },
+			[]string{found("/etc/hosts", 0, "file", true), found("/etc/hosts", 1, "file", false)},
+		},
+		{
+			"deleted file",
+			func(name string, hdr *tar.Header) bool { return name == "etc/passwd" },
+			[]string{found("/etc/passwd", 0, "file", true), found("/etc/passwd", 1, "whiteout", false)},
+		},
+		{
+			"opaque directory",
+			func(name string, hdr *tar.Header) bool { return strings.HasPrefix(name, "data") },
+			[]string{
+				found("/data", 0, "dir", false), found("/data/a", 0, "file", true),
+				found("/data", 1, "opaque", false), found("/data/b", 1, "file", false),
+			},
+		},
+		{
+			"directory replaced by a file",
+			func(name string, hdr *tar.Header) bool { return strings.HasPrefix(name, "opt") },
+			[]string{found("/opt", 0, "dir", true), found("/opt/tool", 0, "file", true), found("/opt", 2, "file", false)},
+		},
+		{
+			"no executable files",
+			func(name string, hdr *tar.Header) bool { return hdr.Mode&0111 != 0 && hdr.Typeflag == tar.TypeReg },
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := Find(layers, tt.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range matches {
+				got = append(got, found(m.Path, m.LayerIndex, m.Type, m.Hidden))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Find = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
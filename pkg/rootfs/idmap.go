@@ -0,0 +1,67 @@
+package rootfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IDMap - maps a range of uids (or gids) in the image to ids on the host, like /proc/self/uid_map
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// ParseIDMaps - parses comma separated container:host:size ranges (0:100000:65536)
+func ParseIDMaps(s string) ([]IDMap, error) {
+	var maps []IDMap
+	if s == "" {
+		return maps, nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(r), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("id map %q must be container:host:size", r)
+		}
+		var ids [3]int
+		for i, p := range parts {
+			id, err := strconv.Atoi(p)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("id map %q must be container:host:size", r)
+			}
+			ids[i] = id
+		}
+		if ids[2] == 0 {
+			return nil, fmt.Errorf("id map %q has an empty range", r)
+		}
+		maps = append(maps, IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return maps, nil
+}
+
+// toHost - the host id for an id in the image, ids are unchanged without maps
+func toHost(maps []IDMap, id int) (int, error) {
+	if len(maps) == 0 {
+		return id, nil
+	}
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, nil
+		}
+	}
+	return 0, fmt.Errorf("id %d is not mapped", id)
+}
+
+// toContainer - the id in the image for an id on the host, ids are unchanged without maps
+func toContainer(maps []IDMap, id int) (int, error) {
+	if len(maps) == 0 {
+		return id, nil
+	}
+	for _, m := range maps {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID, nil
+		}
+	}
+	return 0, fmt.Errorf("host id %d is not mapped", id)
+}
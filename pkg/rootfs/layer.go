@@ -0,0 +1,146 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LayerOptions - how the entries of a new layer are written, the same input always gives the same layer
+// a negative UID or GID keeps the owner of the source, a zero mode keeps its permissions
+type LayerOptions struct {
+	UID      int
+	GID      int
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	ModTime  time.Time
+}
+
+// WriteDir - writes the content of dir as a layer (uncompressed tar), dir is the root of the image
+// returns the number of entries written
+func WriteDir(dir string, opts LayerOptions, w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+	var count int
+	// first name written for each inode, later names become hard links
+	links := map[uint64]string{}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if fi.Mode()&(os.ModeSocket|os.ModeNamedPipe|os.ModeDevice) != 0 {
+			return fmt.Errorf("%s is not a file, directory or symlink", p)
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid = owner(fi)
+		if ino, nlink := inode(fi); hdr.Typeflag == tar.TypeReg && nlink > 1 {
+			if first, ok := links[ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				links[ino] = hdr.Name
+			}
+		}
+		normalize(hdr, opts)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		count++
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, tw.Close()
+}
+
+// RewriteTar - copies the entries of a tar (uncompressed) to a layer, applying the options to every entry
+// returns the number of entries written
+func RewriteTar(r io.Reader, opts LayerOptions, w io.Writer) (int, error) {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	var count int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/")
+		}
+		normalize(hdr, opts)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return count, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, tw.Close()
+}
+
+// normalize - applies the layer options and drops what makes a layer depend on the host it was built on
+func normalize(hdr *tar.Header, opts LayerOptions) {
+	if opts.UID >= 0 {
+		hdr.Uid = opts.UID
+	}
+	if opts.GID >= 0 {
+		hdr.Gid = opts.GID
+	}
+	switch {
+	case hdr.Typeflag == tar.TypeDir && opts.DirMode != 0:
+		hdr.Mode = hdr.Mode&^0777 | int64(opts.DirMode.Perm())
+	case (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) && opts.FileMode != 0:
+		hdr.Mode = hdr.Mode&^0777 | int64(opts.FileMode.Perm())
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.ModTime = opts.ModTime
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+	for k := range hdr.PAXRecords {
+		if k == "atime" || k == "ctime" || k == "mtime" {
+			delete(hdr.PAXRecords, k)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package rootfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maximum number of symlinks followed when resolving a path, like the kernel
+const maxSymlinks int = 255
+
+// resolvePath - the path on disk of name inside root, symlinks in the parent directories are followed
+// as if root was / so layer entries can never be written outside of it (the last element is not followed)
+func resolvePath(root, name string) (string, error) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return root, nil
+	}
+	remaining := strings.Split(name, "/")
+	last := remaining[len(remaining)-1]
+	remaining = remaining[:len(remaining)-1]
+	var current string
+	var hops int
+	for len(remaining) > 0 {
+		p := remaining[0]
+		remaining = remaining[1:]
+		switch p {
+		case "", ".":
+			continue
+		case "..":
+			current = strings.Trim(path.Dir("/"+current), "/")
+			continue
+		}
+		next := path.Join(current, p)
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		if hops++; hops > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = ""
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(root, filepath.FromSlash(current), last), nil
+}
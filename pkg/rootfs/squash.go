@@ -0,0 +1,151 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+)
+
+// Opener - opens an uncompressed layer, reading it to the end fails when the layer does not match its digest
+type Opener func() (io.ReadCloser, error)
+
+// Squash - merges layers (bottom first, as in the manifest) into one layer (uncompressed tar) written to w
+// entries of higher layers win and whiteouts are applied, they are only written when keepWhiteouts is set
+// (the layer then goes on top of lower layers, whose files the whiteouts still have to hide)
+// returns the number of entries written
+func Squash(layers []Opener, keepWhiteouts bool, w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+	var count int
+	// paths already written (or whited out) by a higher layer
+	seen := map[string]bool{}
+	// paths deleted or replaced by something that is not a directory, everything below them is hidden
+	deleted := map[string]bool{}
+	// directories made opaque, what lower layers have below them is hidden
+	opaque := map[string]bool{}
+	// directories written
+	dirs := map[string]bool{}
+	// hard links are written last, their target may be in a lower layer
+	var links []*tar.Header
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		r, err := layers[i]()
+		if err != nil {
+			return count, err
+		}
+		// hides entries of lower layers only, applied once the layer is done
+		layerDeleted := map[string]bool{}
+		layerOpaque := map[string]bool{}
+		err = func() (err error) {
+			defer func() {
+				if cerr := r.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+				if name == "" {
+					continue
+				}
+				dir, base := path.Split(name)
+				dir = strings.TrimSuffix(dir, "/")
+				if base == whiteoutOpaque || strings.HasPrefix(base, whiteoutPrefix) {
+					target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+					if base == whiteoutOpaque {
+						target = dir
+					}
+					if isHidden(target, deleted, opaque) {
+						continue
+					}
+					if base == whiteoutOpaque {
+						layerOpaque[target] = true
+					} else {
+						layerDeleted[target] = true
+					}
+					if !keepWhiteouts {
+						continue
+					}
+					if base != whiteoutOpaque && seen[target] {
+						if !dirs[target] {
+							continue
+						}
+						// a higher layer made the directory again, only what it has is kept
+						name = path.Join(target, whiteoutOpaque)
+					}
+					if seen[name] {
+						continue
+					}
+					seen[name] = true
+					hdr.Name = name
+					if err := tw.WriteHeader(hdr); err != nil {
+						return err
+					}
+					count++
+					continue
+				}
+				if seen[name] || isHidden(name, deleted, opaque) {
+					continue
+				}
+				seen[name] = true
+				if hdr.Typeflag == tar.TypeDir {
+					dirs[name] = true
+				} else {
+					layerDeleted[name] = true
+				}
+				hdr.Name = name
+				if hdr.Typeflag == tar.TypeDir {
+					hdr.Name += "/"
+				}
+				if hdr.Typeflag == tar.TypeLink {
+					hdr.Linkname = strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+					links = append(links, hdr)
+					continue
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					return err
+				}
+				count++
+			}
+		}()
+		if err != nil {
+			return count, err
+		}
+		for name := range layerDeleted {
+			deleted[name] = true
+		}
+		for name := range layerOpaque {
+			opaque[name] = true
+		}
+	}
+	for _, hdr := range links {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, tw.Close()
+}
+
+// isHidden - checks if a higher layer deleted (or replaced) name or one of its parents, or made a parent opaque
+func isHidden(name string, deleted, opaque map[string]bool) bool {
+	if deleted[name] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if deleted[dir] || opaque[dir] {
+			return true
+		}
+	}
+	return false
+}
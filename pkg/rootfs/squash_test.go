@@ -0,0 +1,141 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// entry - a tar entry of a test layer, directories end with / and hard links are written "name=>target"
+type entry struct {
+	name    string
+	content string
+}
+
+// layer - an uncompressed layer holding entries in order
+func layer(t *testing.T, entries ...entry) Opener {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case strings.Contains(e.name, "=>"):
+			parts := strings.SplitN(e.name, "=>", 2)
+			hdr.Name, hdr.Linkname, hdr.Typeflag, hdr.Size = parts[0], parts[1], tar.TypeLink, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// entries - the entries of a tar in the same notation as entry
+func entries(t *testing.T, data []byte) []entry {
+	t.Helper()
+	var got []entry
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := hdr.Name
+		if hdr.Typeflag == tar.TypeLink {
+			name += "=>" + hdr.Linkname
+		}
+		got = append(got, entry{name, string(content)})
+	}
+}
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		name          string
+		layers        []Opener
+		keepWhiteouts bool
+		want          []entry
+	}{
+		{
+			name: "higher layer wins",
+			layers: []Opener{
+				layer(t, entry{"etc/", ""}, entry{"etc/hosts", "old"}),
+				layer(t, entry{"etc/hosts", "new"}),
+			},
+			want: []entry{{"etc/hosts", "new"}, {"etc/", ""}},
+		},
+		{
+			name: "whiteout removes lower file",
+			layers: []Opener{
+				layer(t, entry{"etc/", ""}, entry{"etc/hosts", "old"}, entry{"etc/passwd", "root"}),
+				layer(t, entry{"etc/.wh.hosts", ""}),
+			},
+			want: []entry{{"etc/", ""}, {"etc/passwd", "root"}},
+		},
+		{
+			name: "opaque directory hides lower content",
+			layers: []Opener{
+				layer(t, entry{"data/", ""}, entry{"data/a", "a"}),
+				layer(t, entry{"data/", ""}, entry{"data/.wh..wh..opq", ""}, entry{"data/b", "b"}),
+			},
+			want: []entry{{"data/", ""}, {"data/b", "b"}},
+		},
+		{
+			name: "whiteouts kept for lower layers",
+			layers: []Opener{
+				layer(t, entry{"etc/", ""}),
+				layer(t, entry{"etc/.wh.hosts", ""}),
+			},
+			keepWhiteouts: true,
+			want:          []entry{{"etc/.wh.hosts", ""}, {"etc/", ""}},
+		},
+		{
+			name: "hard link written after its lower target",
+			layers: []Opener{
+				layer(t, entry{"bin/", ""}, entry{"bin/busybox", "elf"}),
+				layer(t, entry{"bin/sh=>bin/busybox", ""}),
+			},
+			want: []entry{{"bin/", ""}, {"bin/busybox", "elf"}, {"bin/sh=>bin/busybox", ""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			count, err := Squash(tt.layers, tt.keepWhiteouts, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := entries(t, buf.Bytes())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Squash = %v, want %v", got, tt.want)
+			}
+			if count != len(tt.want) {
+				t.Fatalf("Squash count = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
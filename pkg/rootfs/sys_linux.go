@@ -0,0 +1,87 @@
+//go:build linux
+
+package rootfs
+
+import (
+	"archive/tar"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func lchown(p string, uid, gid int) error {
+	return os.Lchown(p, uid, gid)
+}
+
+func setxattr(p, name, value string) error {
+	return unix.Lsetxattr(p, name, []byte(value), 0)
+}
+
+// mknod - creates a device node or fifo from its tar header
+func mknod(p string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	}
+	return unix.Mknod(p, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
+
+// lutimes - sets the times of a path without following symlinks
+func lutimes(p string, t time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(t.UnixNano()), unix.NsecToTimespec(t.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// isPermission - errors expected when unprivileged (or on filesystems without xattrs)
+func isPermission(err error) bool {
+	return os.IsPermission(err) || err == unix.EPERM || err == unix.ENOTSUP || err == unix.EINVAL
+}
+
+// listxattrs - the xattrs of a path (not following symlinks)
+func listxattrs(p string) (map[string]string, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(p, buf); err != nil {
+		return nil, err
+	}
+	xattrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		vsize, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(p, name, value); err != nil {
+			return nil, err
+		}
+		xattrs[name] = string(value[:vsize])
+	}
+	return xattrs, nil
+}
+
+// inode - identifies hard links to the same file
+func inode(fi os.FileInfo) (uint64, int) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino, int(st.Nlink)
+	}
+	return 0, 1
+}
+
+// owner - uid and gid of a file on disk
+func owner(fi os.FileInfo) (int, int) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return 0, 0
+}
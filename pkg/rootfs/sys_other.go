@@ -0,0 +1,61 @@
+//go:build !linux
+
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// errUnsupported - what this platform cannot restore, reported as a warning like a permission error
+var errUnsupported = errors.New("not supported on this platform")
+
+func lchown(p string, uid, gid int) error {
+	err := os.Lchown(p, uid, gid)
+	if err != nil && runtime.GOOS == "windows" {
+		return errUnsupported
+	}
+	return err
+}
+
+// setxattr - xattrs are only restored on linux
+func setxattr(p, name, value string) error {
+	return errUnsupported
+}
+
+// mknod - device nodes are only created on linux
+func mknod(p string, hdr *tar.Header) error {
+	return errUnsupported
+}
+
+func lutimes(p string, t time.Time) error {
+	fi, err := os.Lstat(p)
+	if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		return err
+	}
+	return os.Chtimes(p, t, t)
+}
+
+// isPermission - errors expected when unprivileged or for what this platform does not support
+func isPermission(err error) bool {
+	return os.IsPermission(err) || errors.Is(err, errUnsupported) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// listxattrs - xattrs are only read on linux
+func listxattrs(p string) (map[string]string, error) {
+	return nil, nil
+}
+
+// inode - hard links are not detected on this platform
+func inode(fi os.FileInfo) (uint64, int) {
+	return 0, 1
+}
+
+// owner - ownership is not read on this platform
+func owner(fi os.FileInfo) (int, int) {
+	return 0, 0
+}
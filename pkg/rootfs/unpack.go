@@ -0,0 +1,327 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const (
+	// RootfsDir - the root filesystem inside an unpacked bundle
+	RootfsDir string = "rootfs"
+	// StateFile - the image metadata of an unpacked bundle, used by repack
+	StateFile string = "unpack.json"
+
+	whiteoutPrefix string = ".wh."
+	whiteoutOpaque string = ".wh..wh..opq"
+	xattrPrefix    string = "SCHILY.xattr."
+)
+
+// Options - how ownership is applied when unpacking
+// rootless unpacks only change ownership when id maps are given (and the ids are ours to use)
+type Options struct {
+	UIDMap   []IDMap
+	GIDMap   []IDMap
+	Rootless bool
+}
+
+// Unpacker - applies layers in order to a root filesystem, recording every path in the state
+type Unpacker struct {
+	root  string
+	opts  Options
+	state *schema.UnpackState
+	// final mode and time of directories, applied once all layers are extracted
+	dirs map[string]*tar.Header
+	// paths written by the layer being applied, opaque whiteouts keep them
+	layer map[string]bool
+	// Warnings - what could not be restored (device nodes, xattrs or ownership when unprivileged)
+	Warnings []string
+}
+
+// NewUnpacker - returns an unpacker for root, which must exist
+func NewUnpacker(root string, opts Options, state *schema.UnpackState) *Unpacker {
+	if state.Entries == nil {
+		state.Entries = map[string]*schema.UnpackEntry{}
+	}
+	return &Unpacker{root: root, opts: opts, state: state, dirs: map[string]*tar.Header{}}
+}
+
+// Apply - extracts one (uncompressed) layer, whiteouts remove what the lower layers added
+func (u *Unpacker) Apply(r io.Reader) error {
+	u.layer = map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		base := path.Base(name)
+		dir := strings.Trim(path.Dir("/"+name), "/")
+		switch {
+		case base == whiteoutOpaque:
+			err = u.opaque(dir)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			err = u.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			err = u.extract(name, hdr, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+	}
+}
+
+// Finish - sets the modes and times of directories (kept writable while extracting)
+func (u *Unpacker) Finish() error {
+	var names []string
+	for name := range u.dirs {
+		names = append(names, name)
+	}
+	// deepest first so setting a time is not undone by a child
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		p, err := resolvePath(u.root, name)
+		if err != nil {
+			return err
+		}
+		hdr := u.dirs[name]
+		if err := os.Chmod(p, hdr.FileInfo().Mode()&modeBits); err != nil {
+			return err
+		}
+		if err := os.Chtimes(p, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permissions, setuid, setgid and sticky bits
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+func (u *Unpacker) extract(name string, hdr *tar.Header, r io.Reader) error {
+	p, err := resolvePath(u.root, name)
+	if err != nil {
+		return err
+	}
+	entry := &schema.UnpackEntry{
+		Type:     hdr.Typeflag,
+		Mode:     hdr.Mode,
+		UID:      hdr.Uid,
+		GID:      hdr.Gid,
+		ModTime:  hdr.ModTime,
+		Linkname: hdr.Linkname,
+		Devmajor: hdr.Devmajor,
+		Devminor: hdr.Devminor,
+	}
+	for k, v := range hdr.PAXRecords {
+		if strings.HasPrefix(k, xattrPrefix) {
+			if entry.Xattrs == nil {
+				entry.Xattrs = map[string]string{}
+			}
+			entry.Xattrs[strings.TrimPrefix(k, xattrPrefix)] = v
+		}
+	}
+
+	// anything but a directory replaces what is there, a directory is only replaced by a directory
+	fi, err := os.Lstat(p)
+	if err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		u.forget(name)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if fi == nil || !fi.IsDir() {
+			if err := os.Mkdir(p, 0700); err != nil {
+				return err
+			}
+		}
+		// kept writable for the rest of the layers, the real mode is set by Finish
+		if err := os.Chmod(p, 0700|hdr.FileInfo().Mode()&os.ModePerm); err != nil {
+			return err
+		}
+		u.dirs[name] = hdr
+	case tar.TypeReg, tar.TypeRegA:
+		entry.Type = tar.TypeReg
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, h), r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		entry.Size = n
+		entry.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target := strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+		tp, err := resolvePath(u.root, target)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(tp, p); err != nil {
+			return err
+		}
+		entry.Linkname = target
+		if t, ok := u.state.Entries[target]; ok {
+			entry.Size = t.Size
+			entry.Digest = t.Digest
+		}
+		u.state.Entries[name] = entry
+		u.layer[name] = true
+		// a hard link shares the inode (and so the metadata) of its target
+		return nil
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if u.opts.Rootless && hdr.Typeflag != tar.TypeFifo {
+			entry.Skipped = true
+		} else if err := mknod(p, hdr); err != nil {
+			if !isPermission(err) {
+				return err
+			}
+			entry.Skipped = true
+		}
+		if entry.Skipped {
+			u.warn("skipped device %s", name)
+			u.state.Entries[name] = entry
+			u.layer[name] = true
+			return nil
+		}
+	default:
+		u.warn("skipped %s of unsupported type %q", name, string(hdr.Typeflag))
+		return nil
+	}
+
+	if err := u.chown(p, name, hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeDir {
+		// after chown which clears setuid and setgid
+		if err := os.Chmod(p, hdr.FileInfo().Mode()&modeBits); err != nil {
+			return err
+		}
+	}
+	for k, v := range entry.Xattrs {
+		if err := setxattr(p, k, v); err != nil {
+			if !isPermission(err) {
+				return err
+			}
+			u.warn("xattr %s of %s not set: %v", k, name, err)
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		if err := lutimes(p, hdr.ModTime); err != nil && !isPermission(err) {
+			return err
+		}
+	}
+	u.state.Entries[name] = entry
+	u.layer[name] = true
+	return nil
+}
+
+// chown - applies the (mapped) ownership, unprivileged unpacks leave files owned by us unless ids are mapped
+func (u *Unpacker) chown(p, name string, hdr *tar.Header) error {
+	if u.opts.Rootless && len(u.opts.UIDMap) == 0 && len(u.opts.GIDMap) == 0 {
+		return nil
+	}
+	uid, err := toHost(u.opts.UIDMap, hdr.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := toHost(u.opts.GIDMap, hdr.Gid)
+	if err != nil {
+		return err
+	}
+	if err := lchown(p, uid, gid); err != nil {
+		if !u.opts.Rootless || !isPermission(err) {
+			return err
+		}
+		u.warn("ownership %d:%d of %s not set: %v", uid, gid, name, err)
+	}
+	return nil
+}
+
+// remove - applies a whiteout
+func (u *Unpacker) remove(name string) error {
+	p, err := resolvePath(u.root, name)
+	if err != nil {
+		return err
+	}
+	u.forget(name)
+	return os.RemoveAll(p)
+}
+
+// opaque - removes everything in dir that the lower layers added
+func (u *Unpacker) opaque(dir string) error {
+	p, err := resolvePath(u.root, dir)
+	if err != nil {
+		return err
+	}
+	children, err := ioutil.ReadDir(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		name := path.Join(dir, c.Name())
+		if u.layer[name] {
+			// lower content of directories this layer also has is hidden too
+			if c.IsDir() {
+				if err := u.opaque(name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := u.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget - drops a path (and what is below it) from the state
+func (u *Unpacker) forget(name string) {
+	for n := range u.state.Entries {
+		if n == name || strings.HasPrefix(n, name+"/") {
+			delete(u.state.Entries, n)
+			delete(u.dirs, n)
+		}
+	}
+	delete(u.dirs, name)
+}
+
+func (u *Unpacker) warn(format string, args ...interface{}) {
+	u.Warnings = append(u.Warnings, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,146 @@
+package rootfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// unpack - applies the layers to a new root, returns it with the state
+func unpack(t *testing.T, layers ...Opener) (string, *schema.UnpackState) {
+	t.Helper()
+	root := t.TempDir()
+	state := &schema.UnpackState{}
+	u := NewUnpacker(root, Options{Rootless: true}, state)
+	for _, open := range layers {
+		r, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Apply(r); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+	}
+	if err := u.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return root, state
+}
+
+// tree - the paths below root, directories end with /
+func tree(t *testing.T, root string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			rel += "/"
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// stateNames - the paths recorded in the state
+func stateNames(state *schema.UnpackState) []string {
+	var names []string
+	for name := range state.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUnpackerWhiteouts(t *testing.T) {
+	base := layer(t,
+		entry{"etc/", ""}, entry{"etc/hosts", "hosts"}, entry{"etc/passwd", "root"},
+		entry{"data/", ""}, entry{"data/a", "a"}, entry{"data/sub/", ""}, entry{"data/sub/b", "b"},
+	)
+	tests := []struct {
+		name   string
+		layers []Opener
+		want   []string
+		state  []string
+	}{
+		{
+			name:   "base",
+			layers: []Opener{base},
+			want:   []string{"data/", "data/a", "data/sub/", "data/sub/b", "etc/", "etc/hosts", "etc/passwd"},
+			state:  []string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			name:   "whiteout file",
+			layers: []Opener{base, layer(t, entry{"etc/.wh.hosts", ""})},
+			want:   []string{"data/", "data/a", "data/sub/", "data/sub/b", "etc/", "etc/passwd"},
+			state:  []string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/passwd"},
+		},
+		{
+			name:   "whiteout directory",
+			layers: []Opener{base, layer(t, entry{".wh.data", ""})},
+			want:   []string{"etc/", "etc/hosts", "etc/passwd"},
+			state:  []string{"etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			name:   "opaque directory keeps what the layer adds",
+			layers: []Opener{base, layer(t, entry{"data/", ""}, entry{"data/.wh..wh..opq", ""}, entry{"data/c", "c"})},
+			want:   []string{"data/", "data/c", "etc/", "etc/hosts", "etc/passwd"},
+			state:  []string{"data", "data/c", "etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			name:   "opaque after entries of the same layer",
+			layers: []Opener{base, layer(t, entry{"data/c", "c"}, entry{"data/.wh..wh..opq", ""})},
+			want:   []string{"data/", "data/c", "etc/", "etc/hosts", "etc/passwd"},
+			state:  []string{"data", "data/c", "etc", "etc/hosts", "etc/passwd"},
+		},
+		{
+			name:   "file replaced after whiteout",
+			layers: []Opener{base, layer(t, entry{"etc/.wh.hosts", ""}), layer(t, entry{"etc/hosts", "new"})},
+			want:   []string{"data/", "data/a", "data/sub/", "data/sub/b", "etc/", "etc/hosts", "etc/passwd"},
+			state:  []string{"data", "data/a", "data/sub", "data/sub/b", "etc", "etc/hosts", "etc/passwd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, state := unpack(t, tt.layers...)
+			if got := tree(t, root); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tree = %v, want %v", got, tt.want)
+			}
+			if got := stateNames(state); !reflect.DeepEqual(got, tt.state) {
+				t.Fatalf("state = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestUnpackerHardLinkAndContent(t *testing.T) {
+	root, state := unpack(t,
+		layer(t, entry{"bin/", ""}, entry{"bin/busybox", "elf"}, entry{"bin/sh=>bin/busybox", ""}),
+		layer(t, entry{"bin/busybox", "new"}),
+	)
+	data, err := ioutil.ReadFile(filepath.Join(root, "bin/busybox"))
+	if err != nil || string(data) != "new" {
+		t.Fatalf("bin/busybox = %q (%v), want the content of the upper layer", data, err)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(root, "bin/sh"))
+	if err != nil || string(data) != "elf" {
+		t.Fatalf("bin/sh = %q (%v), want the content it was linked to", data, err)
+	}
+	if e := state.Entries["bin/busybox"]; e == nil || e.Digest != schema.Digest([]byte("new")) {
+		t.Fatalf("state of bin/busybox = %+v, want the digest of its content", e)
+	}
+}
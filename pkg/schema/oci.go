@@ -0,0 +1,299 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// media types defined by the OCI image-spec 1.1
+const (
+	MediaTypeImageManifest           string = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex              string = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageConfig             string = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer              string = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeImageLayerGzip          string = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeImageLayerZstd          string = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeImageLayerNonDistrib    string = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeImageLayerNonDistribGz  string = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeImageLayerNonDistribZst string = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+	MediaTypeEmptyJSON               string = "application/vnd.oci.empty.v1+json"
+	MediaTypeLayoutHeader            string = "application/vnd.oci.layout.header.v1+json"
+)
+
+// media types used by docker registries (converted to their OCI equivalent on copy)
+const (
+	MediaTypeDockerManifestV1       string = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerManifestV1Signed string = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeDockerManifest         string = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList     string = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig           string = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer            string = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer     string = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+// annotation keys defined by the OCI image-spec 1.1
+const (
+	AnnotationRefName         string = "org.opencontainers.image.ref.name"
+	AnnotationCreated         string = "org.opencontainers.image.created"
+	AnnotationBaseImageDigest string = "org.opencontainers.image.base.digest"
+	AnnotationBaseImageName   string = "org.opencontainers.image.base.name"
+)
+
+// layer annotation keys of the zstd:chunked and estargz formats, they describe the table of contents of one compressed blob
+const (
+	AnnotationZstdChunkedManifestChecksum string = "io.github.containers.zstd-chunked.manifest-checksum"
+	AnnotationZstdChunkedManifestPosition string = "io.github.containers.zstd-chunked.manifest-position"
+	AnnotationZstdChunkedTarSplitChecksum string = "io.github.containers.zstd-chunked.tarsplit-checksum"
+	AnnotationZstdChunkedTarSplitPosition string = "io.github.containers.zstd-chunked.tarsplit-position"
+	AnnotationStargzTOCDigest             string = "containerd.io/snapshot/stargz/toc.digest"
+	AnnotationStargzUncompressedSize      string = "io.containers.estargz.uncompressed-size"
+)
+
+// media types and annotation keys of cosign signatures
+const (
+	MediaTypeCosignSimpleSigning string = "application/vnd.dev.cosign.simplesigning.v1+json"
+	ArtifactTypeCosignSignature  string = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	AnnotationCosignSignature    string = "dev.cosignproject.cosign/signature"
+	AnnotationCosignCertificate  string = "dev.sigstore.cosign/certificate"
+	AnnotationCosignChain        string = "dev.sigstore.cosign/chain"
+	AnnotationCosignBundle       string = "dev.sigstore.cosign/bundle"
+	CosignSignatureType          string = "cosign container image signature"
+)
+
+// ImageLayoutVersion - written to the oci-layout file
+const ImageLayoutVersion string = "1.0.0"
+
+// Descriptor - describes the disposition of targeted content
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	URLs         []string          `json:"urls,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Data         []byte            `json:"data,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+}
+
+// Platform - describes the platform an image manifest in an index runs on
+type Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
+}
+
+// String - os/arch[/variant]
+func (p *Platform) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Matches - checks a platform against a list of os/arch[/variant] (an empty list matches everything)
+func (p *Platform) Matches(wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, w := range wanted {
+		parts := strings.Split(w, "/")
+		if len(parts) < 2 || parts[0] != p.OS || parts[1] != p.Architecture {
+			continue
+		}
+		if len(parts) == 2 || parts[2] == p.Variant {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageManifest - oci image manifest (also used for artifacts)
+type ImageManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageIndex - oci image index, also the format of index.json in an oci layout
+type ImageIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageLayout - content of the oci-layout file
+type ImageLayout struct {
+	Version string `json:"imageLayoutVersion"`
+}
+
+// ImageConfig - oci image configuration
+type ImageConfig struct {
+	Created      string          `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	OSVersion    string          `json:"os.version,omitempty"`
+	OSFeatures   []string        `json:"os.features,omitempty"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       ContainerConfig `json:"config,omitempty"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []HistorySchema `json:"history,omitempty"`
+	// keys not modelled above (docker's container, container_config, docker_version ...), written back unchanged
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON - decodes the config keeping the keys it does not model
+func (c *ImageConfig) UnmarshalJSON(data []byte) error {
+	type plain ImageConfig
+	return unmarshalKeepingUnknown(data, (*plain)(c), &c.Unknown)
+}
+
+// MarshalJSON - encodes the config with the keys it does not model after the others
+func (c ImageConfig) MarshalJSON() ([]byte, error) {
+	type plain ImageConfig
+	return marshalWithUnknown(plain(c), c.Unknown)
+}
+
+// ContainerConfig - execution parameters used when running a container from the image
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+	ArgsEscaped  bool                `json:"ArgsEscaped,omitempty"`
+	// docker extensions, kept so configs built by docker are not changed by mutate
+	Healthcheck *HealthConfig `json:"Healthcheck,omitempty"`
+	OnBuild     []string      `json:"OnBuild,omitempty"`
+	Shell       []string      `json:"Shell,omitempty"`
+	// keys not modelled above (docker's Hostname, Image, AttachStdin ...), written back unchanged
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON - decodes the execution parameters keeping the keys it does not model
+func (c *ContainerConfig) UnmarshalJSON(data []byte) error {
+	type plain ContainerConfig
+	return unmarshalKeepingUnknown(data, (*plain)(c), &c.Unknown)
+}
+
+// MarshalJSON - encodes the execution parameters with the keys they do not model after the others
+func (c ContainerConfig) MarshalJSON() ([]byte, error) {
+	type plain ContainerConfig
+	return marshalWithUnknown(plain(c), c.Unknown)
+}
+
+// HealthConfig - docker healthcheck of an image config (durations are in nanoseconds)
+type HealthConfig struct {
+	Test        []string `json:"Test,omitempty"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
+}
+
+// unmarshalKeepingUnknown - decodes data into the struct v points to, the keys that are not json fields of v are stored in unknown
+func unmarshalKeepingUnknown(data []byte, v interface{}, unknown *map[string]json.RawMessage) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		// encoding/json matches keys case insensitively
+		for key := range all {
+			if strings.EqualFold(key, name) {
+				delete(all, key)
+			}
+		}
+	}
+	*unknown = nil
+	if len(all) > 0 {
+		*unknown = all
+	}
+	return nil
+}
+
+// marshalWithUnknown - encodes v and appends the unknown keys (sorted) that v did not write itself
+func marshalWithUnknown(v interface{}, unknown map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(unknown) == 0 {
+		return data, err
+	}
+	var written map[string]json.RawMessage
+	if err := json.Unmarshal(data, &written); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range unknown {
+		if _, ok := written[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for i, key := range keys {
+		if i > 0 || len(written) > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(unknown[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// RootFS - references the layer content addresses (uncompressed) used by the image
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// SimpleSigning - the payload of a cosign signature, it binds the signature to a manifest digest
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
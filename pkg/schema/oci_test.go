@@ -0,0 +1,68 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestImageConfigUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		edit func(c *ImageConfig)
+		want string
+	}{
+		{
+			name: "oci config unchanged",
+			data: `{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`,
+			want: `{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`,
+		},
+		{
+			name: "docker keys kept",
+			data: `{"architecture":"amd64","os":"linux","docker_version":"20.10.17","container":"abc",` +
+				`"container_config":{"Cmd":["/bin/sh"]},"config":{"Hostname":"","Image":"sha256:1","Env":["PATH=/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`,
+			want: `{"architecture":"amd64","os":"linux","docker_version":"20.10.17","container":"abc",` +
+				`"container_config":{"Cmd":["/bin/sh"]},"config":{"Hostname":"","Image":"sha256:1","Env":["PATH=/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`,
+		},
+		{
+			name: "edits merged with the unknown keys",
+			data: `{"architecture":"amd64","os":"linux","docker_version":"20.10.17","config":{"Hostname":"h","User":"root"},"rootfs":{"type":"layers","diff_ids":[]}}`,
+			edit: func(c *ImageConfig) {
+				c.Config.User = "nobody"
+				c.Config.Labels = map[string]string{"a": "b"}
+			},
+			want: `{"architecture":"amd64","os":"linux","docker_version":"20.10.17","config":{"Hostname":"h","User":"nobody","Labels":{"a":"b"}},"rootfs":{"type":"layers","diff_ids":[]}}`,
+		},
+		{
+			name: "null config",
+			data: `{"architecture":"amd64","os":"linux","config":null,"rootfs":{"type":"layers","diff_ids":[]}}`,
+			want: `{"architecture":"amd64","os":"linux","config":{},"rootfs":{"type":"layers","diff_ids":[]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c ImageConfig
+			if err := json.Unmarshal([]byte(tt.data), &c); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				tt.edit(&c)
+			}
+			data, err := json.Marshal(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: %v", data, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("config = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,9 @@
 package schema
 
-// OCIImageManifest - oci image manifest
-type OCIImageManifest struct {
-	SchemaVersion int `json:"schemaVersion"`
-	Config        struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int    `json:"size"`
-	} `json:"config"`
-	Layers      []Layer `json:"layers"`
-	Annotations struct {
-		OrgOpencontainersImageBaseDigest string `json:"org.opencontainers.image.base.digest"`
-		OrgOpencontainersImageBaseName   string `json:"org.opencontainers.image.base.name"`
-	} `json:"annotations"`
-}
+import (
+	"encoding/json"
+	"time"
+)
 
 // ManifestSchema - manifest from registry
 type ManifestSchema struct {
@@ -27,34 +17,11 @@ type ManifestSchema struct {
 	FsLayers []FsLayer `json:"fsLayers"`
 }
 
-// Layer schemaVersion 2
-type Layer struct {
-	MediaType string `json:"mediaType"`
-	Digest    string `json:"digest"`
-	Size      int    `json:"size"`
-}
-
 // FsLayer - schemaVersion 1 - blobsum for each layer
 type FsLayer struct {
 	BlobSum string `json:"blobSum"`
 }
 
-// Index - used to crete index.json in oci layout
-type Index struct {
-	SchemaVersion int        `json:"schemaVersion"`
-	Manifests     []Manifest `json:"manifests"`
-}
-
-// Manifest - used in newly create oci manifest
-type Manifest struct {
-	MediaType   string `json:"mediaType"`
-	Digest      string `json:"digest"`
-	Size        int    `json:"size"`
-	Annotations struct {
-		OrgOpencontainersImageRefName string `json:"org.opencontainers.image.ref.name"`
-	} `json:"annotations"`
-}
-
 // Compatibility - taken from History[0].V1Compatibility in ManifestSchema
 type Compatibility struct {
 	Created      string `json:"created"`
@@ -97,6 +64,8 @@ type Compatibility struct {
 	ID      string          `json:"id"`
 	Comment string          `json:"comment,omitempty"`
 	Author  string          `json:"author,omitempty"`
+	// Throwaway - set for history entries that did not produce a layer
+	Throwaway bool `json:"throwaway,omitempty"`
 }
 
 // ContainerConfigSchema used to extract ContainerConfig.Cmd
@@ -108,10 +77,11 @@ type ContainerConfigSchema struct {
 
 // HistorySchema used in Manifest
 type HistorySchema struct {
-	Created   string `json:"created"`
-	CreatedBy string `json:"created_by,omitempty"`
-	Author    string `json:"author,omitempty"`
-	Comment   string `json:"comment,omitempty"`
+	Created    string `json:"created"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
 }
 
 // ServiceSchema - holds all relevent data
@@ -125,6 +95,102 @@ type ServiceSchema struct {
 	URL       string
 	TLS       bool
 	Auth      bool
+	Archive   string
+	Format    string
+	// catalog filters
+	Packages []string
+	Channel  string
+	Versions string
+	// platforms (os/arch[/variant]) to copy from an index, all if empty
+	Platforms []string
+	Config    string
+	// when set copy mirrors every matching tag of the repository
+	Tags TagFilter
+	// namespace repository globs and listing only mode
+	Include []string
+	Exclude []string
+	DryRun  bool
+	// ownership mapping for unpack and repack (container:host:size, comma separated)
+	UIDMap string
+	GIDMap string
+	// layer compression for repack, append and flatten (gzip, zstd or none)
+	Compression string
+	// compression layers are rewritten with on copy and push (gzip, zstd or none), kept if empty
+	Recompress string
+	// owner (uid[:gid]) and octal permissions of appended files, empty keeps them
+	Owner    string
+	FileMode string
+	DirMode  string
+	// number of top layers flatten merges, all if zero
+	Squash int
+	// diff also compares the files of the images
+	Files bool
+	// find only reports entries with any of these mode bits set (octal)
+	Perm string
+	// config changes made by mutate
+	Edits ConfigEdits
+	// signatures copy verifies images against
+	Signatures SignaturePolicy
+	// copy and push also copy the signatures, attestations and SBOMs of images
+	Artifacts bool
+	// private key (PEM) sign signs images with
+	SigningKey string
+	// the image reference (registry/repository) the signature payload names, the repository in the layout if empty
+	SigningReference string
+}
+
+// SignaturePolicy - how copy treats the cosign signatures of an image, checked offline against local keys or roots
+// Mode is ignore, warn (missing or invalid signatures are reported) or enforce (the copy fails), enforce if empty and keys or roots are set
+type SignaturePolicy struct {
+	Mode string
+	// public key files (PEM), a signature made with any of them is accepted
+	Keys []string
+	// CA certificates file (PEM) that the certificate of a signature must chain to
+	Roots string
+	// the subject (email or uri) and oidc issuer a certificate must be issued for, and the rekor public key file (PEM)
+	// its transparency log entry must be signed with, all are required with Roots
+	Identity string
+	Issuer   string
+	RekorKey string
+}
+
+// IsSet - true if signatures are verified
+func (p SignaturePolicy) IsSet() bool {
+	return p.Mode != "ignore" && (p.Mode != "" || len(p.Keys) > 0 || p.Roots != "")
+}
+
+// ConfigEdits - changes mutate makes to the config of an image, fields that are nil (or empty) are left as they are
+// Env, Labels and Annotations are KEY=VALUE (a bare KEY removes it), ExposedPorts are port[/protocol]
+// an empty (non nil) Entrypoint or Cmd clears it
+type ConfigEdits struct {
+	Env          []string
+	Labels       []string
+	Annotations  []string
+	ExposedPorts []string
+	Entrypoint   []string
+	Cmd          []string
+	User         *string
+	WorkingDir   *string
+}
+
+// IsSet - true if any change is set
+func (e ConfigEdits) IsSet() bool {
+	return len(e.Env) > 0 || len(e.Labels) > 0 || len(e.Annotations) > 0 || len(e.ExposedPorts) > 0 ||
+		e.Entrypoint != nil || e.Cmd != nil || e.User != nil || e.WorkingDir != nil
+}
+
+// TagFilter - selects tags of a repository, every filter that is set must match
+// Latest keeps the N highest tags by semver after the other filters are applied
+type TagFilter struct {
+	Regex   string   `json:"tagRegex,omitempty"`
+	Range   string   `json:"tagRange,omitempty"`
+	Latest  int      `json:"latest,omitempty"`
+	Exclude []string `json:"excludeTags,omitempty"`
+}
+
+// IsSet - true if any filter is set
+func (f TagFilter) IsSet() bool {
+	return f.Regex != "" || f.Range != "" || f.Latest > 0 || len(f.Exclude) > 0
 }
 
 // BasicAuth struct
@@ -132,3 +198,197 @@ type BasicAuth struct {
 	User     string
 	Password string
 }
+
+// DeclarativeConfig - an object of an operator file-based catalog (olm.package, olm.channel or olm.bundle)
+type DeclarativeConfig struct {
+	Schema         string         `json:"schema"`
+	Name           string         `json:"name"`
+	Package        string         `json:"package,omitempty"`
+	DefaultChannel string         `json:"defaultChannel,omitempty"`
+	Image          string         `json:"image,omitempty"`
+	Entries        []ChannelEntry `json:"entries,omitempty"`
+	Properties     []Property     `json:"properties,omitempty"`
+	RelatedImages  []RelatedImage `json:"relatedImages,omitempty"`
+}
+
+// ChannelEntry - bundle in an olm.channel
+type ChannelEntry struct {
+	Name      string   `json:"name"`
+	Replaces  string   `json:"replaces,omitempty"`
+	Skips     []string `json:"skips,omitempty"`
+	SkipRange string   `json:"skipRange,omitempty"`
+}
+
+// Property - typed property of an olm.bundle (olm.package holds the packageName and version)
+type Property struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PackageProperty - value of the olm.package property
+type PackageProperty struct {
+	PackageName string `json:"packageName"`
+	Version     string `json:"version"`
+}
+
+// RelatedImage - image referenced by an olm.bundle
+type RelatedImage struct {
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image"`
+}
+
+// RepositoryList - response of the /v2/_catalog endpoint
+type RepositoryList struct {
+	Repositories []string `json:"repositories"`
+}
+
+// TagList - response of the /v2/<name>/tags/list endpoint
+type TagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// ImageSetConfig - declarative mirror configuration (yaml or json)
+type ImageSetConfig struct {
+	Destination string              `json:"destination"`
+	TLSVerify   *bool               `json:"tlsVerify,omitempty"`
+	BasicAuth   bool                `json:"basicAuth,omitempty"`
+	Concurrency int                 `json:"concurrency,omitempty"`
+	Images      []ImageSetImage     `json:"images,omitempty"`
+	Namespaces  []ImageSetNamespace `json:"namespaces,omitempty"`
+	Catalogs    []ImageSetCatalog   `json:"catalogs,omitempty"`
+}
+
+// ImageSetImage - repository to mirror, with explicit tags and/or tag filters (latest if neither is set)
+type ImageSetImage struct {
+	Name      string   `json:"name"`
+	Tags      []string `json:"tags,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+	TagFilter
+}
+
+// ImageSetNamespace - every repository below a registry namespace (e.g. quay.io/ourorg), all tags unless filtered
+type ImageSetNamespace struct {
+	Name      string   `json:"name"`
+	Include   []string `json:"include,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+	TagFilter
+}
+
+// ImageSetCatalog - operator catalog to mirror with its package filters
+type ImageSetCatalog struct {
+	Name      string   `json:"name"`
+	Packages  []string `json:"packages,omitempty"`
+	Channel   string   `json:"channel,omitempty"`
+	Versions  string   `json:"versions,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+}
+
+// ImageInspect - summary of an image (or of each image in an index) used by inspect
+type ImageInspect struct {
+	Name         string            `json:"name,omitempty"`
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Platform     string            `json:"platform,omitempty"`
+	Created      string            `json:"created,omitempty"`
+	Author       string            `json:"author,omitempty"`
+	Config       string            `json:"config,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	User         string            `json:"user,omitempty"`
+	WorkingDir   string            `json:"workingDir,omitempty"`
+	Layers       []Descriptor      `json:"layers,omitempty"`
+	Size         int64             `json:"size"`
+	Missing      []string          `json:"missing,omitempty"`
+	Manifests    []ImageInspect    `json:"manifests,omitempty"`
+}
+
+// RefSummary - a ref of an oci layout as listed by layout ls
+type RefSummary struct {
+	Ref       string   `json:"ref"`
+	Digest    string   `json:"digest"`
+	MediaType string   `json:"mediaType"`
+	Platforms []string `json:"platforms,omitempty"`
+	Size      int64    `json:"size"`
+	Missing   []string `json:"missing,omitempty"`
+}
+
+// LayoutSummary - the refs of an oci layout and the blobs no ref uses
+type LayoutSummary struct {
+	Refs         []RefSummary `json:"refs"`
+	Unreferenced []string     `json:"unreferenced,omitempty"`
+}
+
+// UnpackState - written next to an unpacked rootfs, what the image says about every path
+// repack compares the rootfs against it and restores ownership that rootless unpacks cannot keep
+type UnpackState struct {
+	Ref      string                  `json:"ref"`
+	Manifest string                  `json:"manifest"`
+	Config   string                  `json:"config"`
+	Entries  map[string]*UnpackEntry `json:"entries"`
+}
+
+// UnpackEntry - the tar header fields of a path in the image (and the digest of regular files)
+type UnpackEntry struct {
+	Type     byte              `json:"type"`
+	Mode     int64             `json:"mode"`
+	UID      int               `json:"uid"`
+	GID      int               `json:"gid"`
+	Size     int64             `json:"size,omitempty"`
+	ModTime  time.Time         `json:"modTime"`
+	Linkname string            `json:"linkname,omitempty"`
+	Devmajor int64             `json:"devmajor,omitempty"`
+	Devminor int64             `json:"devminor,omitempty"`
+	Xattrs   map[string]string `json:"xattrs,omitempty"`
+	Digest   string            `json:"digest,omitempty"`
+	// device nodes are not created by unprivileged unpacks
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// ImageDiff - what changed between two images, as reported by diff
+type ImageDiff struct {
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	FromDigest    string         `json:"fromDigest"`
+	ToDigest      string         `json:"toDigest"`
+	SharedLayers  []string       `json:"sharedLayers,omitempty"`
+	RemovedLayers []string       `json:"removedLayers,omitempty"`
+	AddedLayers   []string       `json:"addedLayers,omitempty"`
+	Config        []ConfigChange `json:"config,omitempty"`
+	Files         *FileDiff      `json:"files,omitempty"`
+}
+
+// ConfigChange - a config field (env and labels per key) that is different, empty when not set
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// FileDiff - paths added, removed or modified (content, type, mode, owner or xattrs, not times)
+type FileDiff struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+// FileMatch - an entry of a layer reported by find, whiteouts (type whiteout or opaque) report the path they delete
+// Hidden is set when a higher layer replaces or deletes the entry (it is not in the final image)
+type FileMatch struct {
+	Path       string `json:"path"`
+	Layer      string `json:"layer"`
+	LayerIndex int    `json:"layerIndex"`
+	Type       string `json:"type"`
+	Mode       int64  `json:"mode"`
+	UID        int    `json:"uid"`
+	GID        int    `json:"gid"`
+	Size       int64  `json:"size"`
+	Linkname   string `json:"linkname,omitempty"`
+	Whiteout   bool   `json:"whiteout,omitempty"`
+	Hidden     bool   `json:"hidden,omitempty"`
+}
@@ -0,0 +1,208 @@
+package schema
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// see https://github.com/opencontainers/image-spec/blob/main/descriptor.md#digests
+	digestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+	// see RFC 6838 section 4.2
+	mediaTypeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+)
+
+// Digest - returns the sha256 digest (in "sha256:<hex>" form) of the given data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ValidateDigest - checks a digest string is well formed for the registered algorithms
+func ValidateDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	i := strings.Index(digest, ":")
+	alg, encoded := digest[:i], digest[i+1:]
+	switch alg {
+	case "sha256":
+		if len(encoded) != 64 {
+			return fmt.Errorf("invalid sha256 digest length %q", digest)
+		}
+	case "sha512":
+		if len(encoded) != 128 {
+			return fmt.Errorf("invalid sha512 digest length %q", digest)
+		}
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", alg)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil || strings.ToLower(encoded) != encoded {
+		return fmt.Errorf("invalid digest encoding %q", digest)
+	}
+	return nil
+}
+
+// VerifyContent - checks data matches the size and digest of a descriptor
+func VerifyContent(d Descriptor, data []byte) error {
+	if int64(len(data)) != d.Size {
+		return fmt.Errorf("size mismatch for %s: expected %d got %d", d.Digest, d.Size, len(data))
+	}
+	var encoded string
+	switch {
+	case strings.HasPrefix(d.Digest, "sha256:"):
+		sum := sha256.Sum256(data)
+		encoded = "sha256:" + hex.EncodeToString(sum[:])
+	case strings.HasPrefix(d.Digest, "sha512:"):
+		sum := sha512.Sum512(data)
+		encoded = "sha512:" + hex.EncodeToString(sum[:])
+	default:
+		return fmt.Errorf("unsupported digest %q", d.Digest)
+	}
+	if encoded != d.Digest {
+		return fmt.Errorf("digest mismatch: expected %s got %s", d.Digest, encoded)
+	}
+	return nil
+}
+
+// Validate - checks the required fields of a descriptor
+func (d Descriptor) Validate() error {
+	if !mediaTypeRegexp.MatchString(d.MediaType) {
+		return fmt.Errorf("descriptor %s: invalid media type %q", d.Digest, d.MediaType)
+	}
+	if err := ValidateDigest(d.Digest); err != nil {
+		return fmt.Errorf("descriptor: %v", err)
+	}
+	if d.Size < 0 {
+		return fmt.Errorf("descriptor %s: negative size %d", d.Digest, d.Size)
+	}
+	if d.ArtifactType != "" && !mediaTypeRegexp.MatchString(d.ArtifactType) {
+		return fmt.Errorf("descriptor %s: invalid artifact type %q", d.Digest, d.ArtifactType)
+	}
+	if d.Data != nil {
+		if err := VerifyContent(d, d.Data); err != nil {
+			return fmt.Errorf("descriptor embedded data: %v", err)
+		}
+	}
+	if d.Platform != nil && (d.Platform.OS == "" || d.Platform.Architecture == "") {
+		return fmt.Errorf("descriptor %s: platform requires os and architecture", d.Digest)
+	}
+	return nil
+}
+
+// Validate - checks an image manifest against the image-spec rules
+func (m ImageManifest) Validate() error {
+	if m.SchemaVersion != 2 {
+		return fmt.Errorf("manifest: unsupported schemaVersion %d", m.SchemaVersion)
+	}
+	if m.MediaType != "" && m.MediaType != MediaTypeImageManifest {
+		return fmt.Errorf("manifest: unexpected media type %q", m.MediaType)
+	}
+	if err := m.Config.Validate(); err != nil {
+		return fmt.Errorf("manifest config: %v", err)
+	}
+	if m.Config.MediaType == MediaTypeEmptyJSON && m.ArtifactType == "" {
+		return fmt.Errorf("manifest: artifactType is required when config is %s", MediaTypeEmptyJSON)
+	}
+	if m.ArtifactType != "" && !mediaTypeRegexp.MatchString(m.ArtifactType) {
+		return fmt.Errorf("manifest: invalid artifact type %q", m.ArtifactType)
+	}
+	for _, l := range m.Layers {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("manifest layer: %v", err)
+		}
+	}
+	if m.Subject != nil {
+		if err := m.Subject.Validate(); err != nil {
+			return fmt.Errorf("manifest subject: %v", err)
+		}
+	}
+	return nil
+}
+
+// Validate - checks an image index against the image-spec rules
+func (i ImageIndex) Validate() error {
+	if i.SchemaVersion != 2 {
+		return fmt.Errorf("index: unsupported schemaVersion %d", i.SchemaVersion)
+	}
+	if i.MediaType != "" && i.MediaType != MediaTypeImageIndex {
+		return fmt.Errorf("index: unexpected media type %q", i.MediaType)
+	}
+	if i.ArtifactType != "" && !mediaTypeRegexp.MatchString(i.ArtifactType) {
+		return fmt.Errorf("index: invalid artifact type %q", i.ArtifactType)
+	}
+	for _, m := range i.Manifests {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("index manifest: %v", err)
+		}
+	}
+	if i.Subject != nil {
+		if err := i.Subject.Validate(); err != nil {
+			return fmt.Errorf("index subject: %v", err)
+		}
+	}
+	return nil
+}
+
+// ParseImageManifest - unmarshals and validates an image manifest
+func ParseImageManifest(data []byte) (ImageManifest, error) {
+	var m ImageManifest
+	if err := unmarshalStrict(data, &m); err != nil {
+		return m, fmt.Errorf("manifest: %v", err)
+	}
+	return m, m.Validate()
+}
+
+// ParseImageIndex - unmarshals and validates an image index
+func ParseImageIndex(data []byte) (ImageIndex, error) {
+	var i ImageIndex
+	if err := unmarshalStrict(data, &i); err != nil {
+		return i, fmt.Errorf("index: %v", err)
+	}
+	return i, i.Validate()
+}
+
+// DetectMediaType - guesses the media type of a manifest when the mediaType field is absent
+func DetectMediaType(data []byte) string {
+	var probe struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType"`
+		Manifests     json.RawMessage `json:"manifests"`
+		Config        json.RawMessage `json:"config"`
+		FsLayers      json.RawMessage `json:"fsLayers"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return ""
+	}
+	switch {
+	case probe.MediaType != "":
+		return probe.MediaType
+	case probe.SchemaVersion == 1 || probe.FsLayers != nil:
+		return MediaTypeDockerManifestV1
+	case probe.Manifests != nil:
+		return MediaTypeImageIndex
+	case probe.Config != nil:
+		return MediaTypeImageManifest
+	}
+	return ""
+}
+
+// unmarshalStrict - json decode that rejects trailing data after the document
+func unmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// More ignores a closing ] or }, only the end of the input is accepted
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after json document")
+	}
+	return nil
+}
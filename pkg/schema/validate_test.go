@@ -0,0 +1,181 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	testSHA512 = "sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"
+)
+
+func TestValidateDigest(t *testing.T) {
+	tests := []struct {
+		digest string
+		err    string
+	}{
+		{testDigest, ""},
+		{testSHA512, ""},
+		{"sha256:abc", "invalid sha256 digest length"},
+		{"sha512:" + testDigest[7:], "invalid sha512 digest length"},
+		{"md5:d41d8cd98f00b204e9800998ecf8427e", "unsupported digest algorithm"},
+		{"sha256:" + strings.ToUpper(testDigest[7:]), "invalid digest encoding"},
+		{"sha256:" + strings.Repeat("g", 64), "invalid digest encoding"},
+		{testDigest[7:], "invalid digest"},
+		{"", "invalid digest"},
+	}
+	for _, tt := range tests {
+		err := ValidateDigest(tt.digest)
+		if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("ValidateDigest(%q) = %v, want %q", tt.digest, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyContent(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Descriptor
+		data []byte
+		err  string
+	}{
+		{"sha256", Descriptor{Digest: testDigest, Size: 0}, nil, ""},
+		{"sha512", Descriptor{Digest: testSHA512, Size: 0}, []byte{}, ""},
+		{"size", Descriptor{Digest: testDigest, Size: 1}, nil, "size mismatch"},
+		{"digest", Descriptor{Digest: Digest([]byte("a")), Size: 1}, []byte("b"), "digest mismatch"},
+		{"algorithm", Descriptor{Digest: "md5:d41d8cd98f00b204e9800998ecf8427e", Size: 0}, nil, "unsupported digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyContent(tt.d, tt.data)
+			if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("VerifyContent = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDescriptorValidate(t *testing.T) {
+	valid := Descriptor{MediaType: MediaTypeImageLayerGzip, Digest: testDigest, Size: 0}
+	tests := []struct {
+		name   string
+		change func(d *Descriptor)
+		err    string
+	}{
+		{"valid", func(d *Descriptor) {}, ""},
+		{"media type", func(d *Descriptor) { d.MediaType = "layer" }, "invalid media type"},
+		{"digest", func(d *Descriptor) { d.Digest = "sha256:abc" }, "invalid sha256 digest length"},
+		{"negative size", func(d *Descriptor) { d.Size = -1 }, "negative size"},
+		{"artifact type", func(d *Descriptor) { d.ArtifactType = "sbom" }, "invalid artifact type"},
+		{"embedded data", func(d *Descriptor) { d.Data = []byte("x") }, "embedded data"},
+		{"embedded data matching", func(d *Descriptor) { d.Data = []byte{} }, ""},
+		{"platform without os", func(d *Descriptor) { d.Platform = &Platform{Architecture: "amd64"} }, "requires os and architecture"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid
+			tt.change(&d)
+			err := d.Validate()
+			if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Validate = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseImageManifest(t *testing.T) {
+	config := `{"mediaType":"` + MediaTypeImageConfig + `","digest":"` + testDigest + `","size":0}`
+	empty := `{"mediaType":"` + MediaTypeEmptyJSON + `","digest":"` + testDigest + `","size":0}`
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"image", `{"schemaVersion":2,"mediaType":"` + MediaTypeImageManifest + `","config":` + config + `,"layers":[]}`, ""},
+		{"no media type", `{"schemaVersion":2,"config":` + config + `,"layers":[]}`, ""},
+		{"artifact", `{"schemaVersion":2,"artifactType":"application/spdx+json","config":` + empty + `,"layers":[]}`, ""},
+		{"artifact without type", `{"schemaVersion":2,"config":` + empty + `,"layers":[]}`, "artifactType is required"},
+		{"schema version", `{"schemaVersion":1,"config":` + config + `,"layers":[]}`, "unsupported schemaVersion"},
+		{"docker media type", `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `","config":` + config + `,"layers":[]}`, "unexpected media type"},
+		{"bad layer", `{"schemaVersion":2,"config":` + config + `,"layers":[{"mediaType":"x","digest":"` + testDigest + `","size":0}]}`, "manifest layer"},
+		{"bad subject", `{"schemaVersion":2,"config":` + config + `,"layers":[],"subject":{"mediaType":"` + MediaTypeImageManifest + `","digest":"sha256:1","size":1}}`, "manifest subject"},
+		{"trailing data", `{"schemaVersion":2,"config":` + config + `,"layers":[]} {}`, "unexpected data"},
+		{"not json", `schemaVersion: 2`, "manifest:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseImageManifest([]byte(tt.data))
+			if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("ParseImageManifest = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseImageIndex(t *testing.T) {
+	child := `{"mediaType":"` + MediaTypeImageManifest + `","digest":"` + testDigest + `","size":0,"platform":{"os":"linux","architecture":"amd64"}}`
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"index", `{"schemaVersion":2,"mediaType":"` + MediaTypeImageIndex + `","manifests":[` + child + `]}`, ""},
+		{"empty", `{"schemaVersion":2,"manifests":[]}`, ""},
+		{"docker list", `{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifestList + `","manifests":[]}`, "unexpected media type"},
+		{"schema version", `{"schemaVersion":1,"manifests":[]}`, "unsupported schemaVersion"},
+		{"artifact type", `{"schemaVersion":2,"artifactType":"sbom","manifests":[]}`, "invalid artifact type"},
+		{"bad manifest", `{"schemaVersion":2,"manifests":[{"mediaType":"` + MediaTypeImageManifest + `","digest":"` + testDigest + `","size":-1}]}`, "index manifest"},
+		{"trailing data", `{"schemaVersion":2,"manifests":[]}]`, "unexpected data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseImageIndex([]byte(tt.data))
+			if (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("ParseImageIndex = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `","config":{}}`, MediaTypeDockerManifest},
+		{`{"schemaVersion":1,"fsLayers":[]}`, MediaTypeDockerManifestV1},
+		{`{"fsLayers":[]}`, MediaTypeDockerManifestV1},
+		{`{"schemaVersion":2,"manifests":[]}`, MediaTypeImageIndex},
+		{`{"schemaVersion":2,"config":{},"layers":[]}`, MediaTypeImageManifest},
+		{`{"schemaVersion":2}`, ""},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if got := DetectMediaType([]byte(tt.data)); got != tt.want {
+			t.Errorf("DetectMediaType(%s) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	arm := &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	tests := []struct {
+		platform *Platform
+		wanted   []string
+		want     bool
+	}{
+		{arm, nil, true},
+		{nil, nil, true},
+		{nil, []string{"linux/amd64"}, false},
+		{arm, []string{"linux/amd64", "linux/arm64"}, true},
+		{arm, []string{"linux/arm64/v8"}, true},
+		{arm, []string{"linux/arm64/v7"}, false},
+		{arm, []string{"linux"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.platform.Matches(tt.wanted); got != tt.want {
+			t.Errorf("%s.Matches(%v) = %v, want %v", tt.platform, tt.wanted, got, tt.want)
+		}
+	}
+}
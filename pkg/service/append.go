@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIAppend - adds a directory (as the root of the image) or a tar file as a new layer on top of ref in the layout at ss.Path
+// the new image is written as newRef (a bare tag keeps the repository of ref), every image of a multi-arch ref gets the layer
+func OCIAppend(ss schema.ServiceSchema, ref, src, newRef string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	opts, err := layerOptions(ss, fi.IsDir())
+	if err != nil {
+		return err
+	}
+
+	l := layout.New(ss.Path)
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	if name := d.Annotations[schema.AnnotationRefName]; name != "" && !strings.ContainsAny(newRef, "/:@") {
+		newRef = layout.Repository(name) + ":" + newRef
+	}
+
+	var entries int
+	layer, diffID, err := writeLayer(ss.Path, ss.Compression, func(w io.Writer) error {
+		if fi.IsDir() {
+			entries, err = rootfs.WriteDir(src, opts, w)
+			return err
+		}
+		r, err := openTar(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		entries, err = rootfs.RewriteTar(r, opts, w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if entries == 0 {
+		return fmt.Errorf("%s is empty", src)
+	}
+	fmt.Println("INFO: new layer ", layer.Digest, " with ", entries, " entries")
+
+	desc, err := rewriteImages(l, d, nil, func(m *schema.ImageManifest, config *schema.ImageConfig) error {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		config.History = append(config.History, schema.HistorySchema{
+			Created:   time.Now().UTC().Format(time.RFC3339),
+			CreatedBy: "oci append " + filepath.Base(src),
+		})
+		m.Layers = append(m.Layers, layer)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	return l.AddRef(desc, newRef)
+}
+
+// layerOptions - the owner, modes and time of appended files
+// directories are owned by root unless ss.Owner is set, tar files keep their owners
+// the time is SOURCE_DATE_EPOCH (the epoch if not set) so the same files always give the same layer
+func layerOptions(ss schema.ServiceSchema, dir bool) (rootfs.LayerOptions, error) {
+	opts := rootfs.LayerOptions{UID: -1, GID: -1, ModTime: time.Unix(0, 0).UTC()}
+	if dir {
+		opts.UID, opts.GID = 0, 0
+	}
+	if ss.Owner != "" {
+		parts := strings.Split(ss.Owner, ":")
+		if len(parts) > 2 {
+			return opts, fmt.Errorf("owner %q must be uid[:gid]", ss.Owner)
+		}
+		uid, err := strconv.Atoi(parts[0])
+		if err != nil || uid < 0 {
+			return opts, fmt.Errorf("owner %q must be uid[:gid]", ss.Owner)
+		}
+		opts.UID, opts.GID = uid, uid
+		if len(parts) == 2 {
+			if opts.GID, err = strconv.Atoi(parts[1]); err != nil || opts.GID < 0 {
+				return opts, fmt.Errorf("owner %q must be uid[:gid]", ss.Owner)
+			}
+		}
+	}
+	var err error
+	if opts.FileMode, err = parseMode(ss.FileMode); err != nil {
+		return opts, err
+	}
+	if opts.DirMode, err = parseMode(ss.DirMode); err != nil {
+		return opts, err
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("SOURCE_DATE_EPOCH %q is not a number of seconds", epoch)
+		}
+		opts.ModTime = time.Unix(secs, 0).UTC()
+	}
+	return opts, nil
+}
+
+// parseMode - an octal permission (0644), empty is zero
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("mode %q must be octal permissions (0644)", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// openTar - opens a tar file, transparently decompressing gzip and zstd
+func openTar(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := decompressor(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &layerReader{Reader: rc, closers: []io.Closer{rc, f}}, nil
+}
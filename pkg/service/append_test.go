@@ -0,0 +1,148 @@
+package service
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// layerHeaders - the tar headers of a layer of the layout by name
+func layerHeaders(t *testing.T, path, digest string) map[string]*tar.Header {
+	t.Helper()
+	r, err := openUncompressed(path, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[hdr.Name] = hdr
+	}
+}
+
+func TestOCIAppend(t *testing.T) {
+	path := t.TempDir()
+	base := testImage(t, path, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "etc", "pki"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "etc", "pki", "ca.pem"), []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := schema.ServiceSchema{Path: path, Compression: CompressionGzip, Owner: "1001:0", FileMode: "0644"}
+	if err := OCIAppend(ss, "v1", src, "v1-certs"); err != nil {
+		t.Fatal(err)
+	}
+	l := layout.New(path)
+	if d, err := l.Resolve("quay.io/ourorg/app:v1"); err != nil || d.Digest != base.Digest {
+		t.Fatalf("the appended image replaced the original ref: %v", err)
+	}
+	d, err := l.Resolve("quay.io/ourorg/app:v1-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, config, err := readImage(l, d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != 2 || len(config.RootFS.DiffIDs) != 2 {
+		t.Fatalf("appended image has %d layers and %d diff_ids, want 2", len(m.Layers), len(config.RootFS.DiffIDs))
+	}
+	if last := config.History[len(config.History)-1]; last.CreatedBy != "oci append "+filepath.Base(src) || last.EmptyLayer {
+		t.Fatalf("history of the new layer %+v", last)
+	}
+	r, err := openUncompressed(path, m.Layers[1].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := schema.Digest(data); diff != config.RootFS.DiffIDs[1] {
+		t.Fatalf("layer has diff_id %s, the config %s", diff, config.RootFS.DiffIDs[1])
+	}
+	hdr := layerHeaders(t, path, m.Layers[1].Digest)["etc/pki/ca.pem"]
+	if hdr == nil {
+		t.Fatal("layer has no etc/pki/ca.pem")
+	}
+	if hdr.Uid != 1001 || hdr.Gid != 0 || hdr.Mode&0777 != 0644 || !hdr.ModTime.Equal(time.Unix(0, 0)) {
+		t.Fatalf("etc/pki/ca.pem is %d:%d %o %v", hdr.Uid, hdr.Gid, hdr.Mode, hdr.ModTime)
+	}
+
+	// the same files give the same layer
+	if err := OCIAppend(ss, "v1", src, "v1-again"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := l.Resolve("v1-again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, _, err := readImage(l, again.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.Layers[1].Digest != m.Layers[1].Digest {
+		t.Fatalf("appending the same files gave layer %s, then %s", m.Layers[1].Digest, m2.Layers[1].Digest)
+	}
+}
+
+func TestLayerOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		ss    schema.ServiceSchema
+		dir   bool
+		epoch string
+		uid   int
+		gid   int
+		mtime int64
+		err   string
+	}{
+		{"directory", schema.ServiceSchema{}, true, "", 0, 0, 0, ""},
+		{"tar keeps owners", schema.ServiceSchema{}, false, "", -1, -1, 0, ""},
+		{"uid", schema.ServiceSchema{Owner: "1001"}, false, "", 1001, 1001, 0, ""},
+		{"uid and gid", schema.ServiceSchema{Owner: "1001:0"}, true, "", 1001, 0, 0, ""},
+		{"source date epoch", schema.ServiceSchema{}, true, "1700000000", 0, 0, 1700000000, ""},
+		{"bad owner", schema.ServiceSchema{Owner: "root"}, true, "", 0, 0, 0, "must be uid[:gid]"},
+		{"bad gid", schema.ServiceSchema{Owner: "1:2:3"}, true, "", 0, 0, 0, "must be uid[:gid]"},
+		{"bad mode", schema.ServiceSchema{FileMode: "rw"}, true, "", 0, 0, 0, "must be octal"},
+		{"bad epoch", schema.ServiceSchema{}, true, "yesterday", 0, 0, 0, "SOURCE_DATE_EPOCH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", tt.epoch)
+			opts, err := layerOptions(tt.ss, tt.dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("layerOptions error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.UID != tt.uid || opts.GID != tt.gid || opts.ModTime.Unix() != tt.mtime {
+				t.Fatalf("layerOptions = %d:%d %v, want %d:%d %d", opts.UID, opts.GID, opts.ModTime, tt.uid, tt.gid, tt.mtime)
+			}
+		})
+	}
+}
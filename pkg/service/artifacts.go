@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// cosign keeps the signatures, attestations and SBOMs of a digest under its sha256-<hex> tag with these suffixes
+var cosignSuffixes = []string{".sig", ".att", ".sbom"}
+
+// copyArtifacts - copies the referrers and cosign artifacts of the fetched image data (and the manifests of an index) to the layout
+// they are looked up by the digests in the source registry, referrers are stored only as blobs linked to the image by their subject,
+// cosign artifacts as refs named by their tag, artifacts of artifacts (the signature of an SBOM) are copied too
+func copyArtifacts(client *http.Client, ss schema.ServiceSchema, data []byte, mediaType string) error {
+	digests, err := sourceDigests(ss, data, mediaType)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	var copyOf func(digest string) error
+	copyOf = func(digest string) error {
+		if seen[digest] {
+			return nil
+		}
+		seen[digest] = true
+		referrers, err := fetchReferrers(client, ss, digest)
+		if err != nil {
+			return err
+		}
+		for _, r := range referrers {
+			data, mediaType, err := fetchManifest(client, ss, r.Digest)
+			if err != nil {
+				return err
+			}
+			d, err := copyManifest(client, ss, data, mediaType)
+			if err != nil {
+				return err
+			}
+			fmt.Println("INFO: copied referrer ", d.Digest, " ", r.ArtifactType)
+			if err := copyOf(d.Digest); err != nil {
+				return err
+			}
+		}
+		for _, suffix := range cosignSuffixes {
+			tag := referrersTag(digest) + suffix
+			data, mediaType, err := fetchManifest(client, ss, tag)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			d, err := copyManifest(client, ss, data, mediaType)
+			if err != nil {
+				return err
+			}
+			if err := addRef(ss.Path, d, ss.Image+":"+tag); err != nil {
+				return err
+			}
+			fmt.Println("INFO: copied ", tag)
+			if err := copyOf(d.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, digest := range digests {
+		if err := copyOf(digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushArtifacts - pushes the referrers and cosign artifacts in the layout of the image desc (and the manifests of an index)
+// registries without the referrers api get the referrers tag (sha256-<hex>) updated
+func pushArtifacts(client *http.Client, ss schema.ServiceSchema, desc schema.Descriptor) error {
+	l := layout.New(ss.Path)
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	digests, err := imageDigests(ss.Path, desc)
+	if err != nil {
+		return err
+	}
+	all, err := l.Referrers()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	var pushOf func(digest string) error
+	pushOf = func(digest string) error {
+		if seen[digest] {
+			return nil
+		}
+		seen[digest] = true
+		referrers := all[digest]
+		for _, r := range referrers {
+			if err := pushManifest(client, ss, r, r.Digest); err != nil {
+				return err
+			}
+			fmt.Println("INFO: pushed referrer ", r.Digest, " ", r.ArtifactType)
+			if err := pushOf(r.Digest); err != nil {
+				return err
+			}
+		}
+		if len(referrers) > 0 {
+			if err := updateReferrersTag(client, ss, digest, referrers); err != nil {
+				return err
+			}
+		}
+		for _, suffix := range cosignSuffixes {
+			pushed, err := pushCosignTag(client, ss, index, referrersTag(digest)+suffix)
+			if err != nil {
+				return err
+			}
+			if pushed != "" {
+				if err := pushOf(pushed); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, digest := range digests {
+		if err := pushOf(digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushSignatures - pushes the cosign signatures in the layout of the image desc (and the manifests of an index)
+func pushSignatures(client *http.Client, ss schema.ServiceSchema, desc schema.Descriptor) error {
+	index, err := layout.New(ss.Path).Index()
+	if err != nil {
+		return err
+	}
+	digests, err := imageDigests(ss.Path, desc)
+	if err != nil {
+		return err
+	}
+	for _, digest := range digests {
+		if _, err := pushCosignTag(client, ss, index, referrersTag(digest)+".sig"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushCosignTag - pushes the ref named tag (in any repository) as tag, returning its digest if it is in the layout
+// the same tag may be in the layout for several repositories, the first is pushed
+func pushCosignTag(client *http.Client, ss schema.ServiceSchema, index schema.ImageIndex, tag string) (string, error) {
+	for _, m := range index.Manifests {
+		if name := m.Annotations[schema.AnnotationRefName]; name != tag && !strings.HasSuffix(name, ":"+tag) {
+			continue
+		}
+		if err := pushManifest(client, ss, m, tag); err != nil {
+			return "", err
+		}
+		fmt.Println("INFO: pushed ", tag)
+		return m.Digest, nil
+	}
+	return "", nil
+}
+
+// updateReferrersTag - adds referrers to the index tagged sha256-<hex> when the registry has no referrers api
+func updateReferrersTag(client *http.Client, ss schema.ServiceSchema, digest string, referrers []schema.Descriptor) error {
+	req, err := newRequest(ss, http.MethodGet, ss.URL+"/referrers/"+digest, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", schema.MediaTypeImageIndex)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		return transport.CheckError(resp, http.StatusOK)
+	}
+
+	tag := referrersTag(digest)
+	index := schema.ImageIndex{SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex}
+	data, _, err := fetchManifest(client, ss, tag)
+	if err == nil {
+		err = json.Unmarshal(data, &index)
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	changed := false
+	for _, r := range referrers {
+		found := false
+		for _, m := range index.Manifests {
+			found = found || m.Digest == r.Digest
+		}
+		if !found {
+			index.Manifests = append(index.Manifests, r)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: updating referrers tag ", tag)
+	return putManifest(client, ss, data, schema.MediaTypeImageIndex, tag)
+}
+
+// sourceDigests - the digest of fetched image data and, for an index, of its manifests that were copied
+func sourceDigests(ss schema.ServiceSchema, data []byte, mediaType string) ([]string, error) {
+	digests := []string{schema.Digest(data)}
+	if mediaType != schema.MediaTypeImageIndex && mediaType != schema.MediaTypeDockerManifestList {
+		return digests, nil
+	}
+	index, err := schema.ParseImageIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range index.Manifests {
+		if m.Platform.Matches(ss.Platforms) {
+			digests = append(digests, m.Digest)
+		}
+	}
+	return digests, nil
+}
+
+// imageDigests - the digest of an image in the layout and, for an index, of its manifests
+func imageDigests(path string, desc schema.Descriptor) ([]string, error) {
+	digests := []string{desc.Digest}
+	if desc.MediaType != schema.MediaTypeImageIndex && desc.MediaType != schema.MediaTypeDockerManifestList {
+		return digests, nil
+	}
+	data, err := layout.New(path).ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	index, err := schema.ParseImageIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range index.Manifests {
+		digests = append(digests, m.Digest)
+	}
+	return digests, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// label on operator index images pointing to the file-based catalog
+	configsLabel   string = "operators.operatorframework.io.index.configs.v1"
+	defaultConfigs string = "/configs"
+	schemaPackage  string = "olm.package"
+	schemaChannel  string = "olm.channel"
+	schemaBundle   string = "olm.bundle"
+)
+
+// OCICopyCatalog - copies an operator catalog (index) image and all the related images of its file-based catalog
+// bundles can be filtered by package name (ss.Packages), channel (ss.Channel) and version range (ss.Versions)
+func OCICopyCatalog(ss schema.ServiceSchema) error {
+	images, err := CatalogRelatedImages(ss)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for i, img := range images {
+		fmt.Printf("INFO: copying related image [%d/%d] %s\n", i+1, len(images), img)
+		image, version := SplitReference(img)
+		rs, err := NewServiceSchema(image, version, ss.Path, ss.TLS, ss.Auth)
+		if err == nil {
+			rs.Recompress = ss.Recompress
+			rs.Signatures = ss.Signatures
+			rs.Artifacts = ss.Artifacts
+			err = OCICopyToDisk(rs)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %s %v", img, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to copy %d of %d related images", failed, len(images))
+	}
+	return nil
+}
+
+// CatalogRelatedImages - copies the catalog image to disk and returns the (filtered) images its bundles reference
+func CatalogRelatedImages(ss schema.ServiceSchema) ([]string, error) {
+	vr, err := ParseVersionRange(ss.Versions)
+	if err != nil {
+		return nil, err
+	}
+	err = OCICopyToDisk(ss)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := resolveRef(layout.New(ss.Path), ss)
+	if err != nil {
+		return nil, err
+	}
+	_, m, err := layout.New(ss.Path).ResolveImage(desc)
+	if err != nil {
+		return nil, err
+	}
+	data, err := layout.New(ss.Path).ReadBlob(m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var config schema.ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	dir := config.Config.Labels[configsLabel]
+	if dir == "" {
+		dir = defaultConfigs
+	}
+
+	fmt.Println("INFO: reading file-based catalog from ", dir)
+	files, err := extractFiles(ss.Path, m.Layers, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file-based catalog found in %s", dir)
+	}
+	configs, err := parseDeclarativeConfigs(files)
+	if err != nil {
+		return nil, err
+	}
+	images := relatedImages(configs, ss.Packages, ss.Channel, vr)
+	fmt.Printf("INFO: found %d related images\n", len(images))
+	return images, nil
+}
+
+// extractFiles - returns the content of the regular files below dir in the filesystem the layers make
+// rootfs.Select applies the whiteouts (opaque directories included), catalogs are small so the layer it writes is kept in memory
+func extractFiles(path string, layers []schema.Descriptor, dir string) (map[string][]byte, error) {
+	var openers []rootfs.Opener
+	for _, l := range layers {
+		digest := l.Digest
+		openers = append(openers, func() (io.ReadCloser, error) {
+			return openUncompressed(path, digest)
+		})
+	}
+	var buf bytes.Buffer
+	// a missing dir is no error here, the caller reports that no catalog was found
+	if _, _, err := rootfs.Select(openers, []string{dir}, &buf); err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			files[hdr.Name] = data
+		case tar.TypeLink:
+			// written after their targets
+			if data, ok := files[hdr.Linkname]; ok {
+				files[hdr.Name] = data
+			}
+		}
+	}
+}
+
+// parseDeclarativeConfigs - decodes every json (streams of objects) and yaml (multi document) file of a file-based catalog
+func parseDeclarativeConfigs(files map[string][]byte) ([]schema.DeclarativeConfig, error) {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var configs []schema.DeclarativeConfig
+	for _, name := range names {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json":
+			dec := json.NewDecoder(bytes.NewReader(files[name]))
+			for {
+				var dc schema.DeclarativeConfig
+				err := dec.Decode(&dc)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				configs = append(configs, dc)
+			}
+		case ".yaml", ".yml":
+			dec := yaml.NewDecoder(bytes.NewReader(files[name]))
+			for {
+				var doc interface{}
+				err := dec.Decode(&doc)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				if doc == nil {
+					continue
+				}
+				// round trip through json so the property values stay raw json
+				data, err := json.Marshal(doc)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				var dc schema.DeclarativeConfig
+				if err := json.Unmarshal(data, &dc); err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				configs = append(configs, dc)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// relatedImages - collects the bundle and related images of the bundles that pass the filters
+func relatedImages(configs []schema.DeclarativeConfig, packages []string, channel string, vr VersionRange) []string {
+	wantPackage := map[string]bool{}
+	for _, p := range packages {
+		wantPackage[p] = true
+	}
+	inChannel := map[string]bool{}
+	for _, dc := range configs {
+		if dc.Schema == schemaChannel && dc.Name == channel {
+			for _, e := range dc.Entries {
+				inChannel[dc.Package+"/"+e.Name] = true
+			}
+		}
+	}
+
+	found := map[string]bool{}
+	for _, dc := range configs {
+		if dc.Schema != schemaBundle {
+			continue
+		}
+		if len(wantPackage) > 0 && !wantPackage[dc.Package] {
+			continue
+		}
+		if channel != "" && !inChannel[dc.Package+"/"+dc.Name] {
+			continue
+		}
+		if len(vr) > 0 {
+			v, err := bundleVersion(dc)
+			if err != nil || !vr.Contains(v) {
+				continue
+			}
+		}
+		if dc.Image != "" {
+			found[dc.Image] = true
+		}
+		for _, ri := range dc.RelatedImages {
+			if ri.Image != "" {
+				found[ri.Image] = true
+			}
+		}
+	}
+
+	var images []string
+	for img := range found {
+		images = append(images, img)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// bundleVersion - version of a bundle from its olm.package property
+func bundleVersion(dc schema.DeclarativeConfig) (Version, error) {
+	for _, p := range dc.Properties {
+		if p.Type != schemaPackage {
+			continue
+		}
+		var pp schema.PackageProperty
+		if err := json.Unmarshal(p.Value, &pp); err != nil {
+			return Version{}, err
+		}
+		return ParseVersion(pp.Version)
+	}
+	return Version{}, fmt.Errorf("bundle %s has no %s property", dc.Name, schemaPackage)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"archive/tar"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// tarEntry - a layer entry, data is the content of regular files
+type tarEntry struct {
+	hdr  tar.Header
+	data string
+}
+
+// testLayer - writes a layer of the entries (in order) to the layout at path
+func testLayer(t *testing.T, path string, entries ...tarEntry) schema.Descriptor {
+	t.Helper()
+	d, _, err := writeLayer(path, CompressionGzip, func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		for _, e := range entries {
+			hdr := e.hdr
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len(e.data))
+			}
+			if hdr.Mode == 0 {
+				hdr.Mode = 0644
+			}
+			if err := tw.WriteHeader(&hdr); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(tw, e.data); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func file(name, data string) tarEntry {
+	return tarEntry{tar.Header{Name: name, Typeflag: tar.TypeReg}, data}
+}
+
+func TestExtractFiles(t *testing.T) {
+	path := t.TempDir()
+	base := testLayer(t, path,
+		tarEntry{tar.Header{Name: "configs/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		file("configs/a/catalog.json", "a"),
+		file("configs/b/catalog.json", "b"),
+		file("etc/passwd", "root"),
+	)
+	tests := []struct {
+		name  string
+		upper []tarEntry
+		want  map[string][]byte
+	}{
+		{
+			"no changes",
+			nil,
+			map[string][]byte{"configs/a/catalog.json": []byte("a"), "configs/b/catalog.json": []byte("b")},
+		},
+		{
+			"whiteout",
+			[]tarEntry{file("configs/.wh.a", ""), file("configs/c/catalog.json", "c")},
+			map[string][]byte{"configs/b/catalog.json": []byte("b"), "configs/c/catalog.json": []byte("c")},
+		},
+		{
+			// the opaque marker comes without an entry for its directory
+			"opaque directory",
+			[]tarEntry{file("configs/.wh..wh..opq", ""), file("configs/c/catalog.json", "c")},
+			map[string][]byte{"configs/c/catalog.json": []byte("c")},
+		},
+		{
+			"replaced and linked",
+			[]tarEntry{
+				file("configs/a/catalog.json", "a2"),
+				{tar.Header{Name: "configs/d/catalog.json", Typeflag: tar.TypeLink, Linkname: "configs/a/catalog.json"}, ""},
+			},
+			map[string][]byte{"configs/a/catalog.json": []byte("a2"), "configs/b/catalog.json": []byte("b"), "configs/d/catalog.json": []byte("a2")},
+		},
+		{
+			"deleted directory",
+			[]tarEntry{file(".wh.configs", "")},
+			map[string][]byte{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers := []schema.Descriptor{base}
+			if tt.upper != nil {
+				layers = append(layers, testLayer(t, path, tt.upper...))
+			}
+			files, err := extractFiles(path, layers, "/configs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Fatalf("extractFiles = %q, want %q", files, tt.want)
+			}
+		})
+	}
+}
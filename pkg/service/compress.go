@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const (
+	// CompressionGzip - layers are written as tar+gzip (the default)
+	CompressionGzip string = "gzip"
+	// CompressionZstd - layers are written as tar+zstd
+	CompressionZstd string = "zstd"
+	// CompressionNone - layers are written as plain tar
+	CompressionNone string = "none"
+	// compressionZstdChunked and compressionEstargz - formats with a table of contents that are read (as zstd and gzip) but not written
+	compressionZstdChunked string = "zstd:chunked"
+	compressionEstargz     string = "estargz"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// layerMediaType - the oci layer media type for a compression
+func layerMediaType(compression string) (string, error) {
+	switch compression {
+	case CompressionGzip, "":
+		return schema.MediaTypeImageLayerGzip, nil
+	case CompressionZstd:
+		return schema.MediaTypeImageLayerZstd, nil
+	case CompressionNone:
+		return schema.MediaTypeImageLayer, nil
+	case compressionZstdChunked, compressionEstargz:
+		return "", fmt.Errorf("%s layers can be read but not written, use zstd or gzip", compression)
+	}
+	return "", fmt.Errorf("unsupported compression %q (gzip, zstd or none)", compression)
+}
+
+// compressor - wraps w with the compressor for a compression, closing it flushes the stream (not w)
+func compressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip, "":
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q (gzip, zstd or none)", compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// decompressor - wraps r with the decompressor its magic bytes ask for (gzip or zstd), plain tar is read as is
+func decompressor(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// writeLayer - compresses the tar written by fn into a new layer blob of the layout, returns its descriptor and diff_id
+func writeLayer(path, compression string, fn func(w io.Writer) error) (schema.Descriptor, string, error) {
+	mediaType, err := layerMediaType(compression)
+	if err != nil {
+		return schema.Descriptor{}, "", err
+	}
+	diff := sha256.New()
+	d, err := layout.New(path).CreateBlob(func(w io.Writer) error {
+		cw, err := compressor(w, compression)
+		if err != nil {
+			return err
+		}
+		if err := fn(io.MultiWriter(cw, diff)); err != nil {
+			return err
+		}
+		return cw.Close()
+	})
+	if err != nil {
+		return d, "", err
+	}
+	d.MediaType = mediaType
+	atomic.AddInt64(&blobsWritten, 1)
+	atomic.AddInt64(&bytesWritten, d.Size)
+	return d, SHA256 + hex.EncodeToString(diff.Sum(nil)), nil
+}
+
+// layerCompression - the compression of a layer media type, false for media types that are not (distributable) layers
+func layerCompression(mediaType string) (string, bool) {
+	switch mediaType {
+	case schema.MediaTypeImageLayerGzip, schema.MediaTypeDockerLayer:
+		return CompressionGzip, true
+	case schema.MediaTypeImageLayerZstd:
+		return CompressionZstd, true
+	case schema.MediaTypeImageLayer:
+		return CompressionNone, true
+	}
+	return "", false
+}
+
+// detectLayerMediaType - the oci layer media type of a blob in the layout from its magic bytes
+func detectLayerMediaType(path, digest string) (string, error) {
+	f, err := os.Open(layout.New(path).BlobPath(digest))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(magic[:n], gzipMagic):
+		return schema.MediaTypeImageLayerGzip, nil
+	case bytes.HasPrefix(magic[:n], zstdMagic):
+		return schema.MediaTypeImageLayerZstd, nil
+	}
+	return schema.MediaTypeImageLayer, nil
+}
+
+// recompress - rewrites the layers of a manifest (or of every manifest of an index) in the layout with another compression
+// the uncompressed content does not change so the config (and its diff_ids) is kept as is, only the manifests are new
+func recompress(path string, d schema.Descriptor, compression string) (schema.Descriptor, error) {
+	if _, err := layerMediaType(compression); err != nil {
+		return d, err
+	}
+	l := layout.New(path)
+	data, err := l.ReadBlob(d.Digest)
+	if err != nil {
+		return d, err
+	}
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		index, err := schema.ParseImageIndex(data)
+		if err != nil && d.MediaType == schema.MediaTypeImageIndex {
+			return d, err
+		}
+		changed := false
+		for i, m := range index.Manifests {
+			child, err := recompress(path, m, compression)
+			if err != nil {
+				return d, err
+			}
+			if child.Digest != m.Digest {
+				child.Platform, child.Annotations = m.Platform, m.Annotations
+				index.Manifests[i] = child
+				changed = true
+			}
+		}
+		if !changed {
+			return d, nil
+		}
+		index.SchemaVersion = 2
+		index.MediaType = schema.MediaTypeImageIndex
+		if data, err = json.Marshal(index); err != nil {
+			return d, err
+		}
+		return writeBlob(path, schema.MediaTypeImageIndex, data)
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		m, err := schema.ParseImageManifest(data)
+		if err != nil && d.MediaType == schema.MediaTypeImageManifest {
+			return d, err
+		}
+		var config schema.ImageConfig
+		if m.Config.MediaType == schema.MediaTypeImageConfig || m.Config.MediaType == schema.MediaTypeDockerConfig {
+			cdata, err := l.ReadBlob(m.Config.Digest)
+			if err != nil {
+				return d, err
+			}
+			if err := json.Unmarshal(cdata, &config); err != nil {
+				return d, err
+			}
+		}
+		changed := false
+		for i, layer := range m.Layers {
+			current, ok := layerCompression(layer.MediaType)
+			if !ok || current == compression || !l.HasBlob(layer) {
+				continue
+			}
+			digest := layer.Digest
+			nd, diff, err := writeLayer(path, compression, func(w io.Writer) error {
+				r, err := openUncompressed(path, digest)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				_, err = io.Copy(w, r)
+				return err
+			})
+			if err != nil {
+				return d, err
+			}
+			if i < len(config.RootFS.DiffIDs) && config.RootFS.DiffIDs[i] != diff {
+				return d, fmt.Errorf("layer %s has diff_id %s, the config has %s", digest, diff, config.RootFS.DiffIDs[i])
+			}
+			fmt.Println("INFO: recompressed ", digest, " as ", compression, " ", nd.Digest)
+			nd.Annotations = layerAnnotations(layer.Annotations)
+			m.Layers[i] = nd
+			changed = true
+		}
+		if !changed {
+			return d, nil
+		}
+		// zstd has no docker media type, the manifest is written as oci
+		m.SchemaVersion = 2
+		m.MediaType = schema.MediaTypeImageManifest
+		m.Config.MediaType = ociMediaType(m.Config.MediaType)
+		for i := range m.Layers {
+			m.Layers[i].MediaType = ociMediaType(m.Layers[i].MediaType)
+		}
+		if err := m.Validate(); err != nil {
+			return d, err
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return d, err
+		}
+		return writeBlob(path, schema.MediaTypeImageManifest, data)
+	}
+	return d, nil
+}
+
+// compressedAnnotations - layer annotations that only hold for the blob they were made for
+var compressedAnnotations = []string{
+	schema.AnnotationZstdChunkedManifestChecksum,
+	schema.AnnotationZstdChunkedManifestPosition,
+	schema.AnnotationZstdChunkedTarSplitChecksum,
+	schema.AnnotationZstdChunkedTarSplitPosition,
+	schema.AnnotationStargzTOCDigest,
+	schema.AnnotationStargzUncompressedSize,
+}
+
+// layerAnnotations - the annotations of a layer that still hold once it is recompressed
+// zstd:chunked and estargz are written as plain zstd and gzip, their table of contents is not carried over
+func layerAnnotations(annotations map[string]string) map[string]string {
+	var kept map[string]string
+	for k, v := range annotations {
+		drop := false
+		for _, c := range compressedAnnotations {
+			drop = drop || k == c
+		}
+		if drop {
+			continue
+		}
+		if kept == nil {
+			kept = map[string]string{}
+		}
+		kept[k] = v
+	}
+	return kept
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestLayerAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]string
+	}{
+		{"none", nil, nil},
+		{"kept", map[string]string{"org.example.layer": "x"}, map[string]string{"org.example.layer": "x"}},
+		{
+			"zstd:chunked",
+			map[string]string{
+				schema.AnnotationZstdChunkedManifestChecksum: "sha256:abc",
+				schema.AnnotationZstdChunkedManifestPosition: "1:2:3:4",
+				schema.AnnotationZstdChunkedTarSplitChecksum: "sha256:def",
+				schema.AnnotationZstdChunkedTarSplitPosition: "5:6:7",
+				"org.example.layer":                          "x",
+			},
+			map[string]string{"org.example.layer": "x"},
+		},
+		{
+			"estargz",
+			map[string]string{schema.AnnotationStargzTOCDigest: "sha256:abc", schema.AnnotationStargzUncompressedSize: "42"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := layerAnnotations(tt.annotations); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("layerAnnotations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayerMediaType(t *testing.T) {
+	tests := []struct {
+		compression string
+		want        string
+		err         string
+	}{
+		{"", schema.MediaTypeImageLayerGzip, ""},
+		{CompressionGzip, schema.MediaTypeImageLayerGzip, ""},
+		{CompressionZstd, schema.MediaTypeImageLayerZstd, ""},
+		{CompressionNone, schema.MediaTypeImageLayer, ""},
+		{"zstd:chunked", "", "can be read but not written"},
+		{"estargz", "", "can be read but not written"},
+		{"bzip2", "", "unsupported compression"},
+	}
+	for _, tt := range tests {
+		got, err := layerMediaType(tt.compression)
+		if got != tt.want || (tt.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("layerMediaType(%q) = %q, %v, want %q, %q", tt.compression, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestWriteLayer(t *testing.T) {
+	path := t.TempDir()
+	// the layer is staged in the layout, not in the temp directory
+	t.Setenv("TMPDIR", filepath.Join(path, "missing"))
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			d, diff, err := writeLayer(path, compression, func(w io.Writer) error {
+				_, err := io.WriteString(w, "layer content")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff != schema.Digest([]byte("layer content")) {
+				t.Fatalf("diff_id %s is not the digest of the uncompressed content", diff)
+			}
+			data, err := layout.New(path).ReadBlob(d.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := schema.VerifyContent(d, data); err != nil {
+				t.Fatal(err)
+			}
+			r, err := openUncompressed(path, d.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if content, err := ioutil.ReadAll(r); err != nil || string(content) != "layer content" {
+				t.Fatalf("layer content %q, %v", content, err)
+			}
+		})
+	}
+	if temps, _ := filepath.Glob(filepath.Join(path, layout.BlobsDir, "sha256", ".tmp-*")); len(temps) > 0 {
+		t.Fatalf("temp files left in the layout: %v", temps)
+	}
+}
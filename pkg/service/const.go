@@ -1,14 +1,23 @@
 package service
 
 const (
-	manifests   string = "/manifests/"
-	uploads     string = "uploads/"
-	blobs       string = "/blobs/"
-	layers      string = "layers"
-	mediatypeV1 string = "application/vnd.oci.image.manifest.v1+json"
-	blobsPath   string = "/blobs/sha256/"
+	apiVersion string = "/v2/"
+	manifests  string = "/manifests/"
+	uploads    string = "uploads/"
+	tagsList   string = "/tags/list"
+	blobs      string = "/blobs/"
+	layers     string = "layers"
+	blobsPath  string = "/blobs/sha256/"
 	// SHA256 - differntiate with sha256
-	SHA256       string = "sha256:"
-	manifestJSON string = "/manifest.json"
-	indexJSON    string = "/index.json"
+	SHA256        string = "sha256:"
+	manifestJSON  string = "/manifest.json"
+	indexJSON     string = "/index.json"
+	ociLayoutFile string = "/oci-layout"
+	// accept header used when fetching manifests
+	acceptManifests string = "application/vnd.oci.image.manifest.v1+json," +
+		"application/vnd.oci.image.index.v1+json," +
+		"application/vnd.docker.distribution.manifest.v2+json," +
+		"application/vnd.docker.distribution.manifest.list.v2+json," +
+		"application/vnd.docker.distribution.manifest.v1+prettyjws," +
+		"application/vnd.docker.distribution.manifest.v1+json"
 )
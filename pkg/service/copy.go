@@ -7,281 +7,376 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
 	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
 )
 
-var (
-	ba *schema.BasicAuth
-)
-
 // OCICopyToDisk - pulls an image from a given registry and saves it to disk in OCI format
+// when tag filters are set every matching tag of the repository is copied
+// with a signature policy the image is only copied once its cosign signatures are verified
+// ss.Artifacts also copies its signatures, attestations and SBOMs (referrers and cosign tags)
 func OCICopyToDisk(ss schema.ServiceSchema) error {
 
-	repo, err := name.NewRepository(ss.Image)
+	if ss.Tags.IsSet() {
+		return copyMatchingTags(ss)
+	}
+
+	client, err := newClient(ss, transport.PullScope)
 	if err != nil {
 		return err
 	}
 
-	// Fetch credentials based on your docker config file, which is $HOME/.docker/config.json or $DOCKER_CONFIG.
-	auth, err := authn.DefaultKeychain.Resolve(repo.Registry)
+	// create the directory for all the blobs/layers
+	err = os.MkdirAll(ss.Path+blobsPath, 0755)
 	if err != nil {
 		return err
 	}
+	// keeps gc from removing blobs before the ref pointing to them is added
+	release, err := layout.New(ss.Path).RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	// Construct an http.Client that is authorized to pull from gcr.io/google-containers/pause.
-	scopes := []string{repo.Scope(transport.PullScope)}
-	t, err := transport.New(repo.Registry, auth, http.DefaultTransport, scopes)
+	data, mediaType, err := fetchManifest(client, ss, ss.Version)
 	if err != nil {
 		return err
 	}
-	client := &http.Client{Transport: t}
+	if ss.Signatures.IsSet() {
+		if err := verifySignatures(client, ss, schema.Digest(data)); err != nil {
+			return err
+		}
+	}
 
-	// create the directory for all the blobs/layers
-	err = os.MkdirAll(ss.Path+blobsPath, 0777)
+	desc, err := copyManifest(client, ss, data, mediaType)
 	if err != nil {
 		return err
 	}
+	if ss.Recompress != "" {
+		if desc, err = recompress(ss.Path, desc, ss.Recompress); err != nil {
+			return err
+		}
+	}
 
-	// setup the GET request
-	req, err := http.NewRequest(http.MethodGet, ss.URL+manifests+ss.Version, nil)
-	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
-	if ss.Auth {
-		ba, _ = GetBasicAuthCredentials()
-		req.SetBasicAuth(ba.User, ba.Password)
+	// finally add the image to index.json
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	if err := addRef(ss.Path, desc, refName(ss)); err != nil {
+		return err
+	}
+	if ss.Artifacts {
+		if source := schema.Digest(data); desc.Digest != source {
+			fmt.Println("WARN: the image was rewritten, artifacts of ", source, " no longer refer to ", desc.Digest)
+		}
+		return copyArtifacts(client, ss, data, mediaType)
 	}
+	return nil
+}
+
+// fetchManifest - gets a manifest (or index) by tag or digest, returning the raw data and its media type
+func fetchManifest(client *http.Client, ss schema.ServiceSchema, reference string) ([]byte, string, error) {
+	req, err := newRequest(ss, http.MethodGet, ss.URL+manifests+reference, nil)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
+	req.Header.Set("Accept", acceptManifests)
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-
 	defer resp.Body.Close()
 
 	// Assert that we get a 200, otherwise attempt to parse body as a structured error.
 	if err := transport.CheckError(resp, http.StatusOK); err != nil {
-		return err
+		return nil, "", err
 	}
 
-	var ms schema.ManifestSchema
-	// read and convert to schema
 	data, err := ioutil.ReadAll(resp.Body)
-	err = json.Unmarshal(data, &ms)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-
-	// for reference we write the original manifest version to disk
-	err = ioutil.WriteFile(ss.Path+manifestJSON, data, 0777)
-	if err != nil {
-		return err
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if detected := schema.DetectMediaType(data); detected != "" && (mediaType == "" || mediaType == "application/json" || mediaType == "text/plain") {
+		mediaType = detected
 	}
-
-	switch ms.SchemaVersion {
-	case 1:
-		err = convertAndSaveToOCI(client, ss, ms)
-	case 2:
-		// for schemaVersion 2 we use OCIImageManifest
-		var ocim schema.OCIImageManifest
-		err = json.Unmarshal(data, &ocim)
-		if err == nil {
-			err = saveToOCI(client, ss, ocim)
+	if strings.HasPrefix(reference, SHA256) && !strings.HasPrefix(mediaType, "application/vnd.docker.distribution.manifest.v1") {
+		if got := schema.Digest(data); got != reference {
+			return nil, "", fmt.Errorf("manifest digest mismatch: expected %s got %s", reference, got)
 		}
-	default:
-		err = fmt.Errorf("version unknown")
 	}
-	return err
+	return data, mediaType, nil
 }
 
-func saveToOCI(client *http.Client, ss schema.ServiceSchema, ocim schema.OCIImageManifest) error {
-	// download all the blobs/layersa
-	err := os.MkdirAll(ss.Path+blobsPath, 0777)
-	if err != nil {
-		return err
-	}
-	var errs []error
-
-	ch := make(chan byte, 1)
+// keepManifests - true when manifests must be stored as they were fetched, signatures and artifacts refer to their digest
+func keepManifests(ss schema.ServiceSchema) bool {
+	return ss.Artifacts || ss.Signatures.IsSet()
+}
 
-	for _, x := range ocim.Layers {
-		go func(x schema.Layer) {
-			req, err := http.NewRequest(http.MethodGet, ss.URL+blobs+x.Digest, nil)
-			if ss.Auth {
-				req.SetBasicAuth(ba.User, ba.Password)
-			}
-			if err != nil {
-				errs = append(errs, err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			defer resp.Body.Close()
-
-			// read blob fully
-			contents, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				errs = append(errs, err)
-			}
-
-			// wrtite to file
-			err = ioutil.WriteFile(ss.Path+blobsPath+x.Digest[7:], contents, 0777)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			fmt.Println("INFO: writing blob ", x.Digest)
-			ch <- 1
-		}(x)
-		<-ch
-	}
-
-	// check errors
-	for _, e := range errs {
-		if e != nil {
-			return e
+// copyManifest - copies the content referenced by a manifest or index to disk, converting docker formats to OCI
+// docker v2 manifests and manifest lists are kept as they are when keepManifests is true
+func copyManifest(client *http.Client, ss schema.ServiceSchema, data []byte, mediaType string) (schema.Descriptor, error) {
+	switch mediaType {
+	case schema.MediaTypeDockerManifestV1, schema.MediaTypeDockerManifestV1Signed:
+		var ms schema.ManifestSchema
+		if err := json.Unmarshal(data, &ms); err != nil {
+			return schema.Descriptor{}, err
 		}
+		return convertAndSaveToOCI(client, ss, ms)
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		return saveToOCI(client, ss, data, mediaType)
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		return saveIndexToOCI(client, ss, data, mediaType)
 	}
+	return schema.Descriptor{}, fmt.Errorf("unsupported manifest media type %q", mediaType)
+}
 
-	ij, err := json.Marshal(ocim)
+// copyBlob - downloads a blob into the layout unless it is already present
+func copyBlob(client *http.Client, ss schema.ServiceSchema, d schema.Descriptor) error {
+	mu, _ := blobMutexes.LoadOrStore(ss.Path+"/"+d.Digest, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	if layout.New(ss.Path).HasBlob(d) {
+		fmt.Println("INFO: blob exists ", d.Digest)
+		return nil
+	}
+	req, err := newRequest(ss, http.MethodGet, ss.URL+blobs+d.Digest, nil)
 	if err != nil {
 		return err
 	}
-
-	// write the new index.json file
-	err = ioutil.WriteFile(ss.Path+indexJSON, ij, 0777)
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return err
+	}
+	fmt.Println("INFO: writing blob ", d.Digest)
+	return writeBlobFrom(ss.Path, d, resp.Body)
+}
 
-	return nil
+// ociMediaType - maps docker media types to their OCI equivalent
+func ociMediaType(mediaType string) string {
+	switch mediaType {
+	case schema.MediaTypeDockerManifest:
+		return schema.MediaTypeImageManifest
+	case schema.MediaTypeDockerManifestList:
+		return schema.MediaTypeImageIndex
+	case schema.MediaTypeDockerConfig:
+		return schema.MediaTypeImageConfig
+	case schema.MediaTypeDockerLayer:
+		return schema.MediaTypeImageLayerGzip
+	case schema.MediaTypeDockerForeignLayer:
+		return schema.MediaTypeImageLayerNonDistribGz
+	}
+	return mediaType
 }
 
-func convertAndSaveToOCI(client *http.Client, ss schema.ServiceSchema, ms schema.ManifestSchema) error {
-	var cs = schema.Compatibility{}
-	// manipulate the compatibility data
-	compatibility := ms.History[0].V1Compatibility
-	// clean the compatibility data (will become 'Lables' in the manifest.json)
-	clean := strings.Replace(compatibility, "\\", "", -1)
+func saveToOCI(client *http.Client, ss schema.ServiceSchema, data []byte, mediaType string) (schema.Descriptor, error) {
+	var ocim schema.ImageManifest
+	if err := json.Unmarshal(data, &ocim); err != nil {
+		return schema.Descriptor{}, err
+	}
+	if err := ocim.Validate(); err != nil && mediaType == schema.MediaTypeImageManifest {
+		return schema.Descriptor{}, err
+	}
 
-	// unmarshal json to Compatibilty struct
-	err := json.Unmarshal([]byte(clean), &cs)
+	// download the config and all the blobs/layers
+	err := copyBlob(client, ss, ocim.Config)
 	if err != nil {
-		return err
+		return schema.Descriptor{}, err
+	}
+	for _, x := range ocim.Layers {
+		if len(x.URLs) > 0 {
+			fmt.Println("INFO: skipping non distributable layer ", x.Digest)
+			continue
+		}
+		err = copyBlob(client, ss, x)
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
 	}
 
-	cs.Rootfs.Type = layers
-	var ids []string
-	var errs []error
-
-	ch := make(chan byte, 1)
-
-	for _, x := range ms.FsLayers {
-		// launch each layer fetch concurrently
-		go func(x schema.FsLayer) {
-			req, err := http.NewRequest(http.MethodGet, ss.URL+blobs+x.BlobSum, nil)
-			if ss.Auth {
-				req.SetBasicAuth(ba.User, ba.Password)
-			}
-			if err != nil {
-				errs = append(errs, err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			defer resp.Body.Close()
-
-			// read blob fully
-			contents, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				errs = append(errs, err)
-			}
-
-			// write to file
-			err = ioutil.WriteFile(ss.Path+blobsPath+x.BlobSum[7:], contents, 0777)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			fmt.Println("INFO: writing blob ", x.BlobSum)
-			ids = append(ids, x.BlobSum)
-			ch <- 1
-		}(x)
-		<-ch
-	}
-
-	// check errors
-	for _, e := range errs {
-		if e != nil {
-			return e
+	// docker manifests are rewritten with the equivalent OCI media types, unless their digest must be kept
+	storedType := schema.MediaTypeImageManifest
+	if mediaType == schema.MediaTypeDockerManifest && keepManifests(ss) {
+		storedType = mediaType
+	}
+	if storedType != mediaType && mediaType == schema.MediaTypeDockerManifest {
+		ocim.MediaType = schema.MediaTypeImageManifest
+		ocim.Config.MediaType = ociMediaType(ocim.Config.MediaType)
+		for i := range ocim.Layers {
+			ocim.Layers[i].MediaType = ociMediaType(ocim.Layers[i].MediaType)
+		}
+		if err := ocim.Validate(); err != nil {
+			return schema.Descriptor{}, err
+		}
+		data, err = json.Marshal(ocim)
+		if err != nil {
+			return schema.Descriptor{}, err
 		}
 	}
 
-	cs.Rootfs.DiffIds = ids
+	desc, err := writeBlob(ss.Path, storedType, data)
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	desc.ArtifactType = ocim.ArtifactType
+	fmt.Println("INFO: writing manifest ", desc.Digest)
+	return desc, nil
+}
+
+func saveIndexToOCI(client *http.Client, ss schema.ServiceSchema, data []byte, mediaType string) (schema.Descriptor, error) {
+	var index schema.ImageIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return schema.Descriptor{}, err
+	}
+	if err := index.Validate(); err != nil && mediaType == schema.MediaTypeImageIndex {
+		return schema.Descriptor{}, err
+	}
 
-	// add history to manifest
-	var history = schema.HistorySchema{}
-	var hArray []schema.HistorySchema
-	var comp = &schema.Compatibility{}
-	var cc = &schema.ContainerConfigSchema{}
-	for _, h := range ms.History {
-		cleaned := strings.Replace(h.V1Compatibility, "\\", "", -1)
-		err := json.Unmarshal([]byte(cleaned), comp)
+	changed := mediaType != schema.MediaTypeImageIndex && !keepManifests(ss)
+	var selected []schema.Descriptor
+	for _, m := range index.Manifests {
+		if !m.Platform.Matches(ss.Platforms) {
+			fmt.Println("INFO: skipping platform ", m.Platform.String())
+			changed = true
+			continue
+		}
+		child, childType, err := fetchManifest(client, ss, m.Digest)
 		if err != nil {
-			fmt.Println("ERROR ", err)
+			return schema.Descriptor{}, err
 		}
-		err = json.Unmarshal([]byte(cleaned), cc)
+		desc, err := copyManifest(client, ss, child, childType)
 		if err != nil {
-			fmt.Println("ERROR ", err)
+			return schema.Descriptor{}, err
 		}
-		if !strings.Contains(cc.ContainerConfig.Cmd[0], "null") {
-			history.CreatedBy = cc.ContainerConfig.Cmd[0]
+		if desc.Digest != m.Digest || desc.MediaType != m.MediaType {
+			changed = true
+		}
+		desc.Platform = m.Platform
+		desc.Annotations = m.Annotations
+		selected = append(selected, desc)
+	}
+	if len(selected) == 0 {
+		return schema.Descriptor{}, fmt.Errorf("no manifest in index matches platforms %s", strings.Join(ss.Platforms, ","))
+	}
+	index.Manifests = selected
+
+	storedType := mediaType
+	if changed {
+		index.MediaType = schema.MediaTypeImageIndex
+		storedType = schema.MediaTypeImageIndex
+		var err error
+		data, err = json.Marshal(index)
+		if err != nil {
+			return schema.Descriptor{}, err
 		}
-		history.Created = comp.Created
-		history.Author = comp.Author
-		history.Comment = comp.Comment
-		hArray = append(hArray, history)
-		history = schema.HistorySchema{}
-		comp = &schema.Compatibility{}
-		cc = &schema.ContainerConfigSchema{}
 	}
-	cs.History = hArray
 
-	manifest, err := json.Marshal(cs)
+	desc, err := writeBlob(ss.Path, storedType, data)
 	if err != nil {
-		return err
+		return schema.Descriptor{}, err
 	}
+	desc.ArtifactType = index.ArtifactType
+	fmt.Println("INFO: writing index ", desc.Digest)
+	return desc, nil
+}
 
-	// write the new manifest
-	err = ioutil.WriteFile(ss.Path+blobsPath+cs.ID, manifest, 0777)
+func convertAndSaveToOCI(client *http.Client, ss schema.ServiceSchema, ms schema.ManifestSchema) (schema.Descriptor, error) {
+	if len(ms.History) == 0 || len(ms.History) != len(ms.FsLayers) {
+		return schema.Descriptor{}, fmt.Errorf("schemaVersion 1 manifest has %d history entries for %d layers", len(ms.History), len(ms.FsLayers))
+	}
+
+	// the top level v1Compatibility holds the image config
+	var cs = schema.ImageConfig{}
+	err := json.Unmarshal([]byte(ms.History[0].V1Compatibility), &cs)
 	if err != nil {
-		return err
+		return schema.Descriptor{}, err
+	}
+	cs.RootFS = schema.RootFS{Type: layers}
+	cs.History = nil
+	// the v1 layer chain is replaced by rootfs and history (as docker does when it converts schemaVersion 1)
+	for _, key := range []string{"id", "parent", "parent_id", "layer_id", "throwaway", "Size"} {
+		delete(cs.Unknown, key)
 	}
 
-	// finally create the index.json file
-	var index = schema.Index{SchemaVersion: 2}
-	var m = make([]schema.Manifest, 1)
-	m[0].MediaType = mediatypeV1
-	m[0].Digest = SHA256 + cs.ID
-	m[0].Size = len(manifest)
-	m[0].Annotations.OrgOpencontainersImageRefName = ss.Image + ":" + ss.Version
-	index.Manifests = m
+	var ocim = schema.ImageManifest{SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest}
 
-	// marshal index struct to json
-	ij, err := json.Marshal(index)
+	// schemaVersion 1 lists layers (and history) from the top down
+	for i := len(ms.FsLayers) - 1; i >= 0; i-- {
+		var comp = schema.Compatibility{}
+		var cc = schema.ContainerConfigSchema{}
+		err := json.Unmarshal([]byte(ms.History[i].V1Compatibility), &comp)
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		err = json.Unmarshal([]byte(ms.History[i].V1Compatibility), &cc)
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		history := schema.HistorySchema{
+			Created:   comp.Created,
+			CreatedBy: strings.Join(cc.ContainerConfig.Cmd, " "),
+			Author:    comp.Author,
+			Comment:   comp.Comment,
+		}
+		if comp.Throwaway {
+			history.EmptyLayer = true
+			cs.History = append(cs.History, history)
+			continue
+		}
+		cs.History = append(cs.History, history)
+
+		blobSum := ms.FsLayers[i].BlobSum
+		err = copyBlob(client, ss, schema.Descriptor{Digest: blobSum, Size: -1})
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		id, err := diffID(ss.Path, blobSum)
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		fi, err := os.Stat(layout.New(ss.Path).BlobPath(blobSum))
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		// schemaVersion 1 does not say how layers are compressed
+		layerType, err := detectLayerMediaType(ss.Path, blobSum)
+		if err != nil {
+			return schema.Descriptor{}, err
+		}
+		cs.RootFS.DiffIDs = append(cs.RootFS.DiffIDs, id)
+		ocim.Layers = append(ocim.Layers, schema.Descriptor{MediaType: layerType, Digest: blobSum, Size: fi.Size()})
+	}
+
+	config, err := json.Marshal(cs)
 	if err != nil {
-		return err
+		return schema.Descriptor{}, err
 	}
 
-	// write the new index.json file
-	err = ioutil.WriteFile(ss.Path+indexJSON, ij, 0777)
+	// write the new config
+	ocim.Config, err = writeBlob(ss.Path, schema.MediaTypeImageConfig, config)
 	if err != nil {
-		return err
+		return schema.Descriptor{}, err
 	}
+	fmt.Println("INFO: writing config ", ocim.Config.Digest)
 
-	return nil
+	if err := ocim.Validate(); err != nil {
+		return schema.Descriptor{}, err
+	}
+	manifest, err := json.Marshal(ocim)
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+
+	// write the new manifest
+	desc, err := writeBlob(ss.Path, schema.MediaTypeImageManifest, manifest)
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	fmt.Println("INFO: writing manifest ", desc.Digest)
+	return desc, nil
 }
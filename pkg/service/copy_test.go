@@ -0,0 +1,242 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// newRegistry - an in memory registry, the referrers api answers with status (it is not served when 0)
+// it returns the host to use in image references
+func newRegistry(t *testing.T, status int) string {
+	t.Helper()
+	reg := registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 && strings.Contains(r.URL.Path, "/referrers/") {
+			w.WriteHeader(status)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// uploadBlob - uploads a blob to repo in one request
+func uploadBlob(t *testing.T, host, repo, mediaType string, data []byte) schema.Descriptor {
+	t.Helper()
+	d := schema.Descriptor{MediaType: mediaType, Digest: schema.Digest(data), Size: int64(len(data))}
+	resp, err := http.Post("http://"+host+"/v2/"+repo+"/blobs/uploads/?digest="+d.Digest, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload of %s: status %d", d.Digest, resp.StatusCode)
+	}
+	return d
+}
+
+// uploadManifest - uploads a manifest to repo as ref, it returns its descriptor
+func uploadManifest(t *testing.T, host, repo, ref, mediaType string, v interface{}) schema.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://"+host+"/v2/"+repo+"/manifests/"+ref, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload of manifest %s: status %d", ref, resp.StatusCode)
+	}
+	return schema.Descriptor{MediaType: mediaType, Digest: schema.Digest(data), Size: int64(len(data))}
+}
+
+// tarGz - a gzip compressed layer holding files (name to content)
+func tarGz(t *testing.T, files map[string]string) ([]byte, string) {
+	t.Helper()
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(tarball.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gz.Bytes(), schema.Digest(tarball.Bytes())
+}
+
+// dockerImage - uploads a one layer image with a docker v2 manifest to repo as tag
+func dockerImage(t *testing.T, host, repo, tag string) schema.Descriptor {
+	t.Helper()
+	layer, diffID := tarGz(t, map[string]string{"etc/hosts": "127.0.0.1 localhost"})
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture":     "amd64",
+		"os":               "linux",
+		"docker_version":   "20.10.17",
+		"container_config": map[string]interface{}{"Cmd": []string{"/bin/sh"}},
+		"config":           map[string]interface{}{"Env": []string{"PATH=/bin"}},
+		"rootfs":           map[string]interface{}{"type": "layers", "diff_ids": []string{diffID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := schema.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     schema.MediaTypeDockerManifest,
+		Config:        uploadBlob(t, host, repo, schema.MediaTypeDockerConfig, config),
+		Layers:        []schema.Descriptor{uploadBlob(t, host, repo, schema.MediaTypeDockerLayer, layer)},
+	}
+	return uploadManifest(t, host, repo, tag, schema.MediaTypeDockerManifest, m)
+}
+
+// copyImage - copies image:version from the registry at host to the layout at path
+func copyImage(t *testing.T, host, image, version, path string, set func(ss *schema.ServiceSchema)) {
+	t.Helper()
+	ss, err := NewServiceSchema(host+"/"+image, version, path, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set != nil {
+		set(&ss)
+	}
+	if err := OCICopyToDisk(ss); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopyWithArtifactsThenMutate(t *testing.T) {
+	host := newRegistry(t, 0)
+	source := dockerImage(t, host, "x/y", "v1")
+	path := t.TempDir()
+	copyImage(t, host, "x/y", "v1", path, func(ss *schema.ServiceSchema) { ss.Artifacts = true })
+
+	l := layout.New(path)
+	d, err := l.Resolve("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Digest != source.Digest || d.MediaType != schema.MediaTypeDockerManifest {
+		t.Fatalf("copied %s %s, want the docker manifest %s unchanged", d.MediaType, d.Digest, source.Digest)
+	}
+
+	user := "nobody"
+	ss := schema.ServiceSchema{Path: path, Edits: schema.ConfigEdits{User: &user, Env: []string{"A=1"}}}
+	if err := OCIMutate(ss, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	d, err = l.Resolve("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, config, err := readImage(l, d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.MediaType != schema.MediaTypeImageManifest || m.Layers[0].MediaType != schema.MediaTypeImageLayerGzip {
+		t.Fatalf("mutated image is %s with a %s layer, want an oci manifest", d.MediaType, m.Layers[0].MediaType)
+	}
+	if config.Config.User != "nobody" || strings.Join(config.Config.Env, " ") != "PATH=/bin A=1" {
+		t.Fatalf("mutated config has user %q and env %v", config.Config.User, config.Config.Env)
+	}
+}
+
+func TestCopySignAndPushArtifacts(t *testing.T) {
+	src := newRegistry(t, 0)
+	source := dockerImage(t, src, "x/y", "v1")
+	vendor, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedImage(t, src, "x/y", source.Digest, vendor)
+	sbom := uploadBlob(t, src, "x/y", "application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	uploadManifest(t, src, "x/y", referrersTag(source.Digest)+".sbom", schema.MediaTypeImageManifest, schema.ImageManifest{
+		SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, ArtifactType: "application/spdx+json",
+		Config: uploadBlob(t, src, "x/y", schema.MediaTypeEmptyJSON, []byte("{}")), Layers: []schema.Descriptor{sbom},
+	})
+
+	// the vendor signature is verified on copy and copied with the sbom
+	path := t.TempDir()
+	copyImage(t, src, "x/y", "v1", path, func(ss *schema.ServiceSchema) {
+		ss.Artifacts = true
+		ss.Signatures = schema.SignaturePolicy{Keys: []string{publicKeyFile(t, vendor.Public())}}
+	})
+	l := layout.New(path)
+	for _, suffix := range []string{".sig", ".sbom"} {
+		if _, err := l.Resolve(referrersTag(source.Digest) + suffix); err != nil {
+			t.Fatalf("the %s artifact was not copied: %v", suffix, err)
+		}
+	}
+
+	// our own signature is added next to the copied one
+	ours, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := OCISign(schema.ServiceSchema{Path: path, SigningKey: writeKey(t, t.TempDir(), "cosign.key", "PRIVATE KEY", der)}, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newRegistry(t, 0)
+	ss, err := NewServiceSchema(dst+"/x/y", "v1", path, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.Artifacts = true
+	if err := OCIPushToRegistry(ss); err != nil {
+		t.Fatal(err)
+	}
+	client, err := newClient(ss, transport.PullScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fetchManifest(client, ss, referrersTag(source.Digest)+".sbom"); err != nil {
+		t.Fatalf("the sbom was not pushed: %v", err)
+	}
+	// the payload of the vendor signature names the source repository, only the digest is checked
+	for name, key := range map[string]*ecdsa.PrivateKey{"vendor": vendor, "ours": ours} {
+		ss.Signatures = schema.SignaturePolicy{Keys: []string{publicKeyFile(t, key.Public())}}
+		if err := verifySignatures(client, ss, source.Digest); err != nil {
+			t.Fatalf("the %s signature does not verify after push: %v", name, err)
+		}
+	}
+}
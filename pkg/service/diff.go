@@ -0,0 +1,180 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIDiff - compares two images, each is a ref of the layout at ss.Path or a full image reference in a registry
+// ss.Files also compares the files of the images, the layers of both are read (but nothing is written to disk)
+// for multi-arch images the platform we run on (or the first of ss.Platforms) is compared
+func OCIDiff(ss schema.ServiceSchema, from, to string) (schema.ImageDiff, error) {
+	diff := schema.ImageDiff{From: from, To: to}
+	a, err := openSourceImage(ss, from)
+	if err != nil {
+		return diff, err
+	}
+	b, err := openSourceImage(ss, to)
+	if err != nil {
+		return diff, err
+	}
+	diff.FromDigest, diff.ToDigest = a.ii.Digest, b.ii.Digest
+
+	inA := map[string]bool{}
+	for _, l := range a.ii.Layers {
+		inA[l.Digest] = true
+	}
+	inB := map[string]bool{}
+	for _, l := range b.ii.Layers {
+		inB[l.Digest] = true
+		if inA[l.Digest] {
+			diff.SharedLayers = append(diff.SharedLayers, l.Digest)
+		} else {
+			diff.AddedLayers = append(diff.AddedLayers, l.Digest)
+		}
+	}
+	for _, l := range a.ii.Layers {
+		if !inB[l.Digest] {
+			diff.RemovedLayers = append(diff.RemovedLayers, l.Digest)
+		}
+	}
+	diff.Config = configChanges(a.ii, b.ii)
+
+	if ss.Files {
+		fa, err := a.files()
+		if err != nil {
+			return diff, err
+		}
+		fb, err := b.files()
+		if err != nil {
+			return diff, err
+		}
+		diff.Files = fileChanges(fa, fb)
+	}
+	return diff, nil
+}
+
+// configChanges - the config fields (and annotations) that are different, env and labels per key
+func configChanges(a, b schema.ImageInspect) []schema.ConfigChange {
+	var changes []schema.ConfigChange
+	field := func(name, from, to string) {
+		if from != to {
+			changes = append(changes, schema.ConfigChange{Field: name, From: from, To: to})
+		}
+	}
+	command := func(args []string) string {
+		if len(args) == 0 {
+			return ""
+		}
+		data, _ := json.Marshal(args)
+		return string(data)
+	}
+	keys := func(name string, from, to map[string]string) {
+		var all []string
+		for k := range from {
+			all = append(all, k)
+		}
+		for k := range to {
+			if _, ok := from[k]; !ok {
+				all = append(all, k)
+			}
+		}
+		sort.Strings(all)
+		for _, k := range all {
+			field(name+" "+k, from[k], to[k])
+		}
+	}
+	env := func(list []string) map[string]string {
+		m := map[string]string{}
+		for _, kv := range list {
+			m[strings.SplitN(kv, "=", 2)[0]] = kv
+		}
+		return m
+	}
+
+	field("platform", a.Platform, b.Platform)
+	field("created", a.Created, b.Created)
+	field("author", a.Author, b.Author)
+	field("user", a.User, b.User)
+	field("workingDir", a.WorkingDir, b.WorkingDir)
+	field("entrypoint", command(a.Entrypoint), command(b.Entrypoint))
+	field("cmd", command(a.Cmd), command(b.Cmd))
+	keys("env", env(a.Env), env(b.Env))
+	keys("label", a.Labels, b.Labels)
+	keys("annotation", a.Annotations, b.Annotations)
+	return changes
+}
+
+// fileChanges - compares two filesystems, times are ignored
+func fileChanges(a, b map[string]*schema.UnpackEntry) *schema.FileDiff {
+	var fd schema.FileDiff
+	for name, fb := range b {
+		fa, ok := a[name]
+		switch {
+		case !ok:
+			fd.Added = append(fd.Added, name)
+		case fa.Type != fb.Type || fa.Mode != fb.Mode || fa.UID != fb.UID || fa.GID != fb.GID || fa.Size != fb.Size ||
+			fa.Digest != fb.Digest || fa.Linkname != fb.Linkname || fa.Devmajor != fb.Devmajor || fa.Devminor != fb.Devminor ||
+			(len(fa.Xattrs) > 0 || len(fb.Xattrs) > 0) && !reflect.DeepEqual(fa.Xattrs, fb.Xattrs):
+			fd.Modified = append(fd.Modified, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			fd.Removed = append(fd.Removed, name)
+		}
+	}
+	sort.Strings(fd.Added)
+	sort.Strings(fd.Removed)
+	sort.Strings(fd.Modified)
+	return &fd
+}
+
+// PrintDiff - writes the diff as json or human readable text (= shared, - removed, + added, ~ modified)
+func PrintDiff(w io.Writer, diff schema.ImageDiff, output string) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	fmt.Fprintf(w, "%-13s: %s %s\n", "From", diff.From, diff.FromDigest)
+	fmt.Fprintf(w, "%-13s: %s %s\n", "To", diff.To, diff.ToDigest)
+	fmt.Fprintf(w, "%-13s:\n", "Layers")
+	for _, l := range diff.SharedLayers {
+		fmt.Fprintf(w, "    = %s\n", l)
+	}
+	for _, l := range diff.RemovedLayers {
+		fmt.Fprintf(w, "    - %s\n", l)
+	}
+	for _, l := range diff.AddedLayers {
+		fmt.Fprintf(w, "    + %s\n", l)
+	}
+	if len(diff.Config) > 0 {
+		fmt.Fprintf(w, "%-13s:\n", "Config")
+		for _, c := range diff.Config {
+			fmt.Fprintf(w, "    %s: %q -> %q\n", c.Field, c.From, c.To)
+		}
+	}
+	if diff.Files != nil {
+		fmt.Fprintf(w, "%-13s: %d added, %d removed, %d modified\n", "Files", len(diff.Files.Added), len(diff.Files.Removed), len(diff.Files.Modified))
+		for _, f := range diff.Files.Removed {
+			fmt.Fprintf(w, "    - %s\n", f)
+		}
+		for _, f := range diff.Files.Added {
+			fmt.Fprintf(w, "    + %s\n", f)
+		}
+		for _, f := range diff.Files.Modified {
+			fmt.Fprintf(w, "    ~ %s\n", f)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const (
+	// OCIArchive - tarball of an oci layout
+	OCIArchive string = "oci-archive"
+	// DockerArchive - tarball compatible with docker save / podman load
+	DockerArchive string = "docker-archive"
+)
+
+// DockerArchiveManifest - entry in the manifest.json of a docker-archive
+type DockerArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// OCIExportArchive - packs the oci layout at ss.Path into a single tarball (oci-archive or docker-archive)
+// if ss.Version is set only the matching ref is exported, archives ending in .gz or .tgz are gzip compressed
+func OCIExportArchive(ss schema.ServiceSchema) error {
+	l := layout.New(ss.Path)
+	// keeps gc from removing blobs while they are being archived
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	refs := index.Manifests
+	if ss.Version != "" {
+		desc, err := resolveRef(l, ss)
+		if err != nil {
+			return err
+		}
+		refs = []schema.Descriptor{desc}
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no images found in %s", ss.Path+indexJSON)
+	}
+
+	f, err := os.Create(ss.Archive)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	var gw *gzip.Writer
+	if strings.HasSuffix(ss.Archive, ".gz") || strings.HasSuffix(ss.Archive, ".tgz") {
+		gw = gzip.NewWriter(f)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+
+	switch ss.Format {
+	case "", OCIArchive:
+		err = writeOCIArchive(tw, ss.Path, refs)
+	case DockerArchive:
+		err = writeDockerArchive(tw, ss.Path, refs)
+	default:
+		err = fmt.Errorf("archive format %q not recognized", ss.Format)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil && gw != nil {
+		err = gw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(ss.Archive)
+	}
+	return err
+}
+
+// tarHeader - deterministic header for a regular file in an archive
+func tarHeader(name string, size int64) *tar.Header {
+	return &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: time.Unix(0, 0)}
+}
+
+// writeTarFile - adds a file with the given content to the archive
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(tarHeader(name, int64(len(data)))); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// writeTarBlob - streams a blob from the layout into the archive
+func writeTarBlob(tw *tar.Writer, name, path, digest string) error {
+	f, err := os.Open(layout.New(path).BlobPath(digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(tarHeader(name, fi.Size())); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func writeOCIArchive(tw *tar.Writer, path string, refs []schema.Descriptor) error {
+	ociLayout, err := json.Marshal(schema.ImageLayout{Version: schema.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, ociLayoutFile[1:], ociLayout); err != nil {
+		return err
+	}
+	index := schema.ImageIndex{SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex, Manifests: refs}
+	ij, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, indexJSON[1:], ij); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		fmt.Println("INFO: exporting ", ref.Annotations[schema.AnnotationRefName])
+		err := layout.New(path).Walk(ref, func(d schema.Descriptor) error {
+			if seen[d.Digest] {
+				return nil
+			}
+			seen[d.Digest] = true
+			if len(d.URLs) > 0 && !layout.New(path).HasBlob(d) {
+				fmt.Println("INFO: skipping non distributable layer ", d.Digest)
+				return nil
+			}
+			return writeTarBlob(tw, blobs[1:]+strings.Replace(d.Digest, ":", "/", 1), path, d.Digest)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDockerArchive(tw *tar.Writer, path string, refs []schema.Descriptor) error {
+	var dm []DockerArchiveManifest
+	var repositories = map[string]map[string]string{}
+	seen := map[string]bool{}
+
+	for _, ref := range refs {
+		name := ref.Annotations[schema.AnnotationRefName]
+		fmt.Println("INFO: exporting ", name)
+		_, m, err := layout.New(path).ResolveImage(ref)
+		if err != nil {
+			return err
+		}
+		data, err := layout.New(path).ReadBlob(m.Config.Digest)
+		if err != nil {
+			return err
+		}
+		var config schema.ImageConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return err
+		}
+		if len(config.RootFS.DiffIDs) != len(m.Layers) {
+			return fmt.Errorf("config %s has %d diff_ids for %d layers", m.Config.Digest, len(config.RootFS.DiffIDs), len(m.Layers))
+		}
+
+		entry := DockerArchiveManifest{Config: m.Config.Digest[len(SHA256):] + ".json", RepoTags: []string{}}
+		if !seen[m.Config.Digest] {
+			seen[m.Config.Digest] = true
+			if err := writeTarFile(tw, entry.Config, data); err != nil {
+				return err
+			}
+		}
+
+		// docker save stores each layer uncompressed, named after its diff_id
+		for i, l := range m.Layers {
+			id := config.RootFS.DiffIDs[i][len(SHA256):]
+			layer := id + "/layer.tar"
+			entry.Layers = append(entry.Layers, layer)
+			if seen[layer] {
+				continue
+			}
+			seen[layer] = true
+			if err := writeUncompressedLayer(tw, layer, path, l.Digest); err != nil {
+				return err
+			}
+		}
+
+		if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") && !strings.Contains(name, "@") {
+			entry.RepoTags = append(entry.RepoTags, name)
+			if repositories[name[:i]] == nil {
+				repositories[name[:i]] = map[string]string{}
+			}
+			if len(config.RootFS.DiffIDs) > 0 {
+				repositories[name[:i]][name[i+1:]] = config.RootFS.DiffIDs[len(config.RootFS.DiffIDs)-1][len(SHA256):]
+			}
+		}
+		dm = append(dm, entry)
+	}
+
+	mj, err := json.Marshal(dm)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, manifestJSON[1:], mj); err != nil {
+		return err
+	}
+	rj, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, "repositories", rj)
+}
+
+// writeUncompressedLayer - adds a layer to the archive as a plain tar (the uncompressed size is computed first)
+func writeUncompressedLayer(tw *tar.Writer, name, path, digest string) error {
+	f, err := os.Open(layout.New(path).BlobPath(digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var counter countingWriter
+	if err := copyUncompressed(&counter, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(tarHeader(name, int64(counter))); err != nil {
+		return err
+	}
+	return copyUncompressed(tw, f)
+}
+
+// countingWriter - discards data, counting the bytes written
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
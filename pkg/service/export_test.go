@@ -0,0 +1,180 @@
+package service
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// testImage - writes a one layer image holding files (name to content) to the layout at path as ref
+func testImage(t *testing.T, path, ref string, files map[string]string) schema.Descriptor {
+	t.Helper()
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	layer, diff, err := writeLayer(path, CompressionGzip, func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		for _, name := range names {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(tw, files[name]); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := schema.ImageConfig{Architecture: "amd64", OS: "linux", RootFS: schema.RootFS{Type: "layers", DiffIDs: []string{diff}}}
+	d, err := writeImage(path, schema.ImageManifest{Layers: []schema.Descriptor{layer}}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.New(path).AddRef(d, ref); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// readArchive - the regular files of a (possibly gzip compressed) tarball
+func readArchive(t *testing.T, archive string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{}
+	err := walkArchive(archive, func(hdr *tar.Header, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		files[hdr.Name] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExportOCIArchive(t *testing.T) {
+	path := t.TempDir()
+	app := testImage(t, path, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	testImage(t, path, "quay.io/ourorg/tool:v2", map[string]string{"bin/tool": "elf"})
+	for _, archive := range []string{"app.tar", "app.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), archive)
+			ss, err := NewServiceSchema("quay.io/ourorg/app", "v1", path, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ss.Archive = file
+			if err := OCIExportArchive(ss); err != nil {
+				t.Fatal(err)
+			}
+			files := readArchive(t, file)
+			var index schema.ImageIndex
+			if err := json.Unmarshal(files["index.json"], &index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Manifests) != 1 || index.Manifests[0].Digest != app.Digest {
+				t.Fatalf("index.json = %+v, want only %s", index.Manifests, app.Digest)
+			}
+			if files["oci-layout"] == nil {
+				t.Fatal("archive has no oci-layout")
+			}
+			// the manifest, config and layer of the image and nothing of the other one
+			var blobs []string
+			l := layout.New(path)
+			if err := l.Walk(app, func(d schema.Descriptor) error {
+				blobs = append(blobs, "blobs/sha256/"+d.Digest[len(SHA256):])
+				data, err := l.ReadBlob(d.Digest)
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(files["blobs/sha256/"+d.Digest[len(SHA256):]], data) {
+					t.Fatalf("blob %s is not in the archive", d.Digest)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(blobs)+2 {
+				t.Fatalf("archive has %d files, want %d", len(files), len(blobs)+2)
+			}
+		})
+	}
+}
+
+func TestExportDockerArchive(t *testing.T) {
+	path := t.TempDir()
+	testImage(t, path, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	testImage(t, path, "quay.io/ourorg/tool:v2", map[string]string{"bin/tool": "elf"})
+	file := filepath.Join(t.TempDir(), "images.tar")
+	if err := OCIExportArchive(schema.ServiceSchema{Path: path, Archive: file, Format: DockerArchive}); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, file)
+	var dm []DockerArchiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &dm); err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, entry := range dm {
+		tags = append(tags, entry.RepoTags...)
+		var config schema.ImageConfig
+		if err := json.Unmarshal(files[entry.Config], &config); err != nil {
+			t.Fatalf("config %s: %v", entry.Config, err)
+		}
+		// podman load checks each layer.tar against the diff_ids of the config
+		for i, layer := range entry.Layers {
+			sum := sha256.Sum256(files[layer])
+			if got := SHA256 + hex.EncodeToString(sum[:]); got != config.RootFS.DiffIDs[i] {
+				t.Fatalf("%s has digest %s, the config has diff_id %s", layer, got, config.RootFS.DiffIDs[i])
+			}
+		}
+	}
+	sort.Strings(tags)
+	if want := []string{"quay.io/ourorg/app:v1", "quay.io/ourorg/tool:v2"}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("RepoTags = %v, want %v", tags, want)
+	}
+	var repositories map[string]map[string]string
+	if err := json.Unmarshal(files["repositories"], &repositories); err != nil {
+		t.Fatal(err)
+	}
+	if repositories["quay.io/ourorg/app"]["v1"] == "" || repositories["quay.io/ourorg/tool"]["v2"] == "" {
+		t.Fatalf("repositories = %v", repositories)
+	}
+}
+
+func TestExportWaitsForGC(t *testing.T) {
+	path := t.TempDir()
+	testImage(t, path, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	release, err := layout.New(path).Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "app.tar")
+	done := make(chan error)
+	go func() {
+		done <- OCIExportArchive(schema.ServiceSchema{Path: path, Archive: archive})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("export ran while gc held the layout: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
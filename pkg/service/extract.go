@@ -0,0 +1,60 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIExtract - writes the final state of paths in ref (in the layout at ss.Path or a registry) to dir, directories with everything below them
+// layers are read from the top and streamed, the lower ones are not read once every path is known
+// paths are matched as they are stored in the layers, symlinked directories in them are not followed
+func OCIExtract(ss schema.ServiceSchema, ref, dir string, paths []string) error {
+	img, err := openSourceImage(ss, ref)
+	if err != nil {
+		return err
+	}
+	layers, err := img.layers()
+	if err != nil {
+		return err
+	}
+	opts, err := idMapOptions(ss)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	var missing []string
+	var read int
+	go func() {
+		var err error
+		missing, read, err = rootfs.Select(layers, paths, pw)
+		pw.CloseWithError(err)
+	}()
+	u := rootfs.NewUnpacker(dir, opts, &schema.UnpackState{})
+	err = u.Apply(pr)
+	// drains the pipe so Select is done when Apply stopped early
+	io.Copy(io.Discard, pr)
+	if err != nil {
+		return err
+	}
+	if err := u.Finish(); err != nil {
+		return err
+	}
+	for _, w := range u.Warnings {
+		fmt.Println("WARN: ", w)
+	}
+	fmt.Printf("INFO: read %d of %d layers of %s\n", read, len(layers), img.ii.Digest)
+	if len(missing) > 0 {
+		return fmt.Errorf("not in %s: %s", ref, strings.Join(missing, ", "))
+	}
+	fmt.Println("INFO: extracted ", strings.Join(paths, ", "), " to ", dir)
+	return nil
+}
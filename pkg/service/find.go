@@ -0,0 +1,114 @@
+package service
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIFind - the entries of every layer of ref (in the layout at ss.Path or a registry) matching one of the globs
+// a glob with a / is matched against the full path, otherwise against the file name, no globs match every path
+// ss.Perm only keeps entries with any of its mode bits set (4000 finds setuid binaries)
+func OCIFind(ss schema.ServiceSchema, ref string, globs []string) ([]schema.FileMatch, error) {
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("glob %q: %v", g, err)
+		}
+	}
+	var perm int64
+	if ss.Perm != "" {
+		var err error
+		if perm, err = strconv.ParseInt(ss.Perm, 8, 64); err != nil || perm <= 0 || perm > 07777 {
+			return nil, fmt.Errorf("perm %q must be octal mode bits (4000)", ss.Perm)
+		}
+	}
+	img, err := openSourceImage(ss, ref)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.layers()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := rootfs.Find(layers, func(name string, hdr *tar.Header) bool {
+		if perm != 0 && (hdr.Mode&perm == 0 || strings.HasPrefix(path.Base(hdr.Name), ".wh.")) {
+			return false
+		}
+		return matchGlobs(globs, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range matches {
+		matches[i].Layer = img.ii.Layers[matches[i].LayerIndex].Digest
+	}
+	return matches, nil
+}
+
+// matchGlobs - checks name (without a leading /) against the globs of find
+func matchGlobs(globs []string, name string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		target := path.Base(name)
+		if strings.Contains(g, "/") {
+			g, target = strings.TrimPrefix(g, "/"), name
+		}
+		if ok, _ := path.Match(g, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintFind - writes the matches as json or a table
+func PrintFind(w io.Writer, matches []schema.FileMatch, output string) error {
+	if output == "json" {
+		if matches == nil {
+			matches = []schema.FileMatch{}
+		}
+		data, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAYER\tTYPE\tMODE\tOWNER\tSIZE\tPATH\tSTATUS")
+	for _, m := range matches {
+		status := "present"
+		switch {
+		case m.Whiteout && m.Hidden:
+			status = "whiteout (re-added)"
+		case m.Whiteout:
+			status = "whiteout"
+		case m.Hidden:
+			status = "hidden"
+		}
+		name := m.Path
+		if m.Linkname != "" {
+			name += " -> " + m.Linkname
+		}
+		fmt.Fprintf(tw, "%d %s\t%s\t%04o\t%d:%d\t%s\t%s\t%s\n", m.LayerIndex+1, shortDigest(m.Layer), m.Type, m.Mode&07777, m.UID, m.GID, humanSize(m.Size), name, status)
+	}
+	return tw.Flush()
+}
+
+// shortDigest - the first 12 hex characters of a digest
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, SHA256)
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIFlatten - merges the layers of ref in the layout at ss.Path into one layer, written as newRef (a bare tag keeps the repository of ref)
+// when ss.Squash is set only the top ss.Squash layers are merged so the base layers stay shared
+func OCIFlatten(ss schema.ServiceSchema, ref, newRef string) error {
+	if ss.Squash < 0 {
+		return fmt.Errorf("number of layers to squash %d is negative", ss.Squash)
+	}
+	l := layout.New(ss.Path)
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	if name := d.Annotations[schema.AnnotationRefName]; name != "" && !strings.ContainsAny(newRef, "/:@") {
+		newRef = layout.Repository(name) + ":" + newRef
+	}
+
+	desc, err := rewriteImages(l, d, nil, func(m *schema.ImageManifest, config *schema.ImageConfig) error {
+		n := ss.Squash
+		if n == 0 || n > len(m.Layers) {
+			n = len(m.Layers)
+		}
+		if n < 2 {
+			return fmt.Errorf("%s has nothing to squash (%d layers)", ref, len(m.Layers))
+		}
+		if len(config.RootFS.DiffIDs) != len(m.Layers) {
+			return fmt.Errorf("%s has %d layers but %d diff_ids", ref, len(m.Layers), len(config.RootFS.DiffIDs))
+		}
+		keep := len(m.Layers) - n
+		var layers []rootfs.Opener
+		for _, layer := range m.Layers[keep:] {
+			if !l.HasBlob(layer) {
+				return fmt.Errorf("layer %s is not in the layout", layer.Digest)
+			}
+			digest := layer.Digest
+			layers = append(layers, func() (io.ReadCloser, error) {
+				return openUncompressed(ss.Path, digest)
+			})
+		}
+
+		var entries int
+		layer, diffID, err := writeLayer(ss.Path, ss.Compression, func(w io.Writer) error {
+			var err error
+			entries, err = rootfs.Squash(layers, keep > 0, w)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("INFO: squashed ", n, " layers into ", layer.Digest, " with ", entries, " entries")
+
+		m.Layers = append(m.Layers[:keep:keep], layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs[:keep:keep], diffID)
+		// the history of the kept layers (up to the last one) stays, the rest is one entry
+		var history []schema.HistorySchema
+		var layersSeen int
+		for _, h := range config.History {
+			if layersSeen == keep {
+				break
+			}
+			if !h.EmptyLayer {
+				layersSeen++
+			}
+			history = append(history, h)
+		}
+		config.History = append(history, schema.HistorySchema{
+			Created:   time.Now().UTC().Format(time.RFC3339),
+			CreatedBy: "oci flatten",
+			Comment:   fmt.Sprintf("squashed %d layers", n),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	return l.AddRef(desc, newRef)
+}
@@ -1,11 +1,34 @@
 package service
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
 	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// blobs being downloaded, so concurrent copies of images sharing layers fetch them once
+	blobMutexes sync.Map
+	// totals reported by the mirror summary
+	blobsWritten int64
+	bytesWritten int64
 )
 
 // GetBasicAuthCredentials - simple basic auth helper function
@@ -14,8 +37,199 @@ func GetBasicAuthCredentials() (*schema.BasicAuth, error) {
 	base64Text := make([]byte, base64.StdEncoding.DecodedLen(len(creds)))
 	base64.StdEncoding.Decode(base64Text, []byte(creds))
 	hld := strings.Split(string(base64Text), ":")
+	if len(hld) < 2 {
+		return nil, fmt.Errorf("BASIC_AUTH_CREDENTIALS must be base64 encoded user:password")
+	}
 	user := strings.ReplaceAll(hld[0], "\n", "")
-	pwd := strings.ReplaceAll(hld[1], "\n", "")
+	pwd := strings.Trim(strings.ReplaceAll(hld[1], "\n", ""), "\x00")
 	ba := &schema.BasicAuth{User: user, Password: pwd}
 	return ba, nil
 }
+
+// NewServiceSchema - sets up the service schema for an image (registry/user/component) and version (tag or digest)
+func NewServiceSchema(image, version, path string, tls, auth bool) (schema.ServiceSchema, error) {
+	var ss = schema.ServiceSchema{Image: image, Version: version, Path: path, TLS: tls, Auth: auth}
+	var opts []name.Option
+	if !tls {
+		opts = append(opts, name.Insecure)
+	}
+	repo, err := name.NewRepository(image, opts...)
+	if err != nil {
+		return ss, err
+	}
+	ss.Name = repo.RegistryStr()
+	ss.Component = repo.RepositoryStr()
+	if i := strings.LastIndex(ss.Component, "/"); i >= 0 {
+		ss.User = ss.Component[:i+1]
+		ss.Component = ss.Component[i+1:]
+	}
+	scheme := "https://"
+	if !tls {
+		scheme = "http://"
+	}
+	ss.URL = scheme + ss.Name + apiVersion + ss.User + ss.Component
+	return ss, nil
+}
+
+// SplitReference - splits a full image reference into image and version (tag or digest, latest if neither is set)
+func SplitReference(ref string) (string, string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		image, _ := SplitReference(ref[:i])
+		return image, ref[i+1:]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// newClient - constructs an http.Client authorized for the given scope (transport.PullScope or transport.PushScope)
+func newClient(ss schema.ServiceSchema, scope string) (*http.Client, error) {
+	var opts []name.Option
+	if !ss.TLS {
+		opts = append(opts, name.Insecure)
+	}
+	repo, err := name.NewRepository(ss.Image, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch credentials based on your docker config file, which is $HOME/.docker/config.json or $DOCKER_CONFIG.
+	auth, err := authn.DefaultKeychain.Resolve(repo.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{repo.Scope(scope)}
+	t, err := transport.New(repo.Registry, auth, http.DefaultTransport, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// newRequest - sets up a request, adding basic auth when enabled
+func newRequest(ss schema.ServiceSchema, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if ss.Auth {
+		ba, err := GetBasicAuthCredentials()
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(ba.User, ba.Password)
+	}
+	return req, nil
+}
+
+// refName - the org.opencontainers.image.ref.name annotation used for an image in index.json
+func refName(ss schema.ServiceSchema) string {
+	if strings.HasPrefix(ss.Version, SHA256) {
+		return ss.Image + "@" + ss.Version
+	}
+	return ss.Image + ":" + ss.Version
+}
+
+// writeBlob - writes data to the layout and returns its descriptor
+func writeBlob(path, mediaType string, data []byte) (schema.Descriptor, error) {
+	d := schema.Descriptor{MediaType: mediaType, Digest: schema.Digest(data), Size: int64(len(data))}
+	if layout.New(path).HasBlob(d) {
+		return d, nil
+	}
+	return d, writeBlobFrom(path, d, bytes.NewReader(data))
+}
+
+// writeBlobFrom - streams a verified blob into the layout, counting what was written for the mirror summary
+func writeBlobFrom(path string, d schema.Descriptor, r io.Reader) error {
+	n, err := layout.New(path).WriteBlob(d, r)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&blobsWritten, 1)
+	atomic.AddInt64(&bytesWritten, n)
+	return nil
+}
+
+// diffID - sha256 of the uncompressed content of a (possibly gzip compressed) layer blob
+func diffID(path, digest string) (string, error) {
+	f, err := os.Open(layout.New(path).BlobPath(digest))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if err := copyUncompressed(h, f); err != nil {
+		return "", err
+	}
+	return SHA256 + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyUncompressed - copies a layer to w, transparently decompressing gzip and zstd streams
+func copyUncompressed(w io.Writer, f *os.File) error {
+	rc, err := decompressor(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// openUncompressed - opens a layer blob from the layout, transparently decompressing gzip and zstd streams
+func openUncompressed(path, digest string) (io.ReadCloser, error) {
+	return openTar(layout.New(path).BlobPath(digest))
+}
+
+// layerReader - closes the decompressor and the underlying file together
+type layerReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (l *layerReader) Close() error {
+	var err error
+	for _, c := range l.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// decodeYAML - decodes a yaml (or json) document through json so only the json tags of v are used
+func decodeYAML(data []byte, v interface{}) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+// addRef - adds the image to index.json of the layout under its ref name
+func addRef(path string, d schema.Descriptor, ref string) error {
+	return layout.New(path).AddRef(d, ref)
+}
+
+// resolveRef - finds the manifest in index.json for the service schema
+// by (in order) the full ref, the component and version, then the version alone, a ref that matches nothing is an error
+func resolveRef(l *layout.Layout, ss schema.ServiceSchema) (schema.Descriptor, error) {
+	sep := ":"
+	if strings.HasPrefix(ss.Version, SHA256) {
+		sep = "@"
+	}
+	var d schema.Descriptor
+	var err error
+	for _, ref := range []string{refName(ss), ss.Component + sep + ss.Version, ss.Version} {
+		d, err = l.Resolve(ref)
+		if !errors.Is(err, layout.ErrNotFound) {
+			return d, err
+		}
+	}
+	return d, err
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestResolveRef(t *testing.T) {
+	path := t.TempDir()
+	l := layout.New(path)
+	refs := map[string]schema.Descriptor{}
+	for _, ref := range []string{"quay.io/ourorg/app:v1", "quay.io/ourorg/tool:v2", "localhost/tool:v3", "localhost/other:v3"} {
+		d, err := writeBlob(path, schema.MediaTypeImageManifest, []byte(`{"ref":"`+ref+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.AddRef(d, ref); err != nil {
+			t.Fatal(err)
+		}
+		refs[ref] = d
+	}
+	tests := []struct {
+		name    string
+		image   string
+		version string
+		want    string
+		err     string
+	}{
+		{"full ref", "quay.io/ourorg/app", "v1", "quay.io/ourorg/app:v1", ""},
+		{"component and version", "registry.example.com/mirror/tool", "v2", "quay.io/ourorg/tool:v2", ""},
+		{"version alone", "registry.example.com/mirror/app", "v1", "quay.io/ourorg/app:v1", ""},
+		{"digest", "registry.example.com/mirror/app", refs["quay.io/ourorg/app:v1"].Digest, "quay.io/ourorg/app:v1", ""},
+		{"ambiguous version", "registry.example.com/mirror/app", "v3", "", "ambiguous"},
+		{"no match", "quay.io/ourorg/app", "wrongtag", "", "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := NewServiceSchema(tt.image, tt.version, path, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := resolveRef(l, ss)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolveRef error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := d.Annotations[schema.AnnotationRefName]; got != tt.want {
+				t.Fatalf("resolveRef = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// a layout holding one image does not push it under a tag it does not have
+	single := t.TempDir()
+	if err := layout.New(single).AddRef(refs["quay.io/ourorg/app:v1"], "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := NewServiceSchema("quay.io/ourorg/app", "wrongtag", single, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveRef(layout.New(single), ss); err == nil {
+		t.Fatal("resolveRef found the only image of the layout for a tag it does not have")
+	}
+}
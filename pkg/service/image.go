@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// sourceImage - an image (for one platform) and where its blobs are read from, the layout or a registry
+type sourceImage struct {
+	src contentSource
+	ii  schema.ImageInspect
+}
+
+// openSourceImage - finds ref in the layout, or in its registry when it is a full image reference that is not in the layout
+// for multi-arch images the platform we run on (or the first of ss.Platforms) is used
+func openSourceImage(ss schema.ServiceSchema, ref string) (*sourceImage, error) {
+	var src contentSource
+	reference := ref
+	if ss.Path != "" {
+		if _, err := layout.New(ss.Path).Resolve(ref); err == nil {
+			src = &layoutSource{layout: layout.New(ss.Path)}
+		}
+	}
+	if src == nil {
+		if !strings.Contains(ref, "/") {
+			return nil, fmt.Errorf("%s is not in the layout and not a full image reference", ref)
+		}
+		image, version := SplitReference(ref)
+		rs, err := NewServiceSchema(image, version, "", ss.TLS, ss.Auth)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newClient(rs, transport.PullScope)
+		if err != nil {
+			return nil, err
+		}
+		src, reference = &registrySource{client: client, ss: rs}, version
+	}
+
+	data, mediaType, err := src.Manifest(reference)
+	if err != nil {
+		return nil, err
+	}
+	ii, err := inspectManifest(src, ss.Platforms, data, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	if len(ii.Manifests) > 0 {
+		selected := ii.Manifests[0]
+		if len(ss.Platforms) == 0 {
+			for _, m := range ii.Manifests {
+				if m.Platform == runtime.GOOS+"/"+runtime.GOARCH {
+					selected = m
+					break
+				}
+			}
+		}
+		ii = selected
+	}
+	return &sourceImage{src: src, ii: ii}, nil
+}
+
+// files - the filesystem of the image, rebuilt from its layers
+func (d *sourceImage) files() (map[string]*schema.UnpackEntry, error) {
+	layers, err := d.layers()
+	if err != nil {
+		return nil, err
+	}
+	return rootfs.Files(layers)
+}
+
+// layers - opens the layers of the image (bottom first) uncompressed, they are streamed when read from a registry
+func (d *sourceImage) layers() ([]rootfs.Opener, error) {
+	var layers []rootfs.Opener
+	for _, l := range d.ii.Layers {
+		layer := l
+		if len(layer.URLs) > 0 {
+			return nil, fmt.Errorf("layer %s is not distributable", layer.Digest)
+		}
+		layers = append(layers, func() (io.ReadCloser, error) {
+			rc, err := d.src.Open(layer)
+			if err != nil {
+				return nil, err
+			}
+			dc, err := decompressor(rc)
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			return &layerReader{Reader: dc, closers: []io.Closer{dc, rc}}, nil
+		})
+	}
+	return layers, nil
+}
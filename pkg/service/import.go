@@ -0,0 +1,321 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIImportArchive - reads an oci-archive or docker-archive (docker save) tarball into the oci layout at ss.Path
+// the format is detected from the archive contents, docker images are converted to OCI manifests
+func OCIImportArchive(ss schema.ServiceSchema) error {
+	err := os.MkdirAll(ss.Path+blobsPath, 0755)
+	if err != nil {
+		return err
+	}
+	// keeps gc from removing blobs before the ref pointing to them is added
+	release, err := layout.New(ss.Path).RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// first pass collects the metadata (small json files) from the archive
+	meta := map[string][]byte{}
+	err = walkArchive(ss.Archive, func(hdr *tar.Header, r io.Reader) error {
+		name := cleanArchivePath(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(name, ".json") && name != ociLayoutFile[1:] && name != "repositories" {
+			return nil
+		}
+		if strings.HasPrefix(name, blobs[1:]) {
+			return nil
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		meta[name] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case meta[ociLayoutFile[1:]] != nil:
+		return importOCIArchive(ss, meta)
+	case meta[manifestJSON[1:]] != nil:
+		return importDockerArchive(ss, meta)
+	}
+	return fmt.Errorf("%s is not an oci-archive or docker-archive (no oci-layout or manifest.json found)", ss.Archive)
+}
+
+// walkArchive - calls fn for each entry of a (possibly gzip compressed) tarball
+func walkArchive(archive string, fn func(*tar.Header, io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanArchivePath - normalizes entry names such as ./index.json
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+name)), "/")
+}
+
+func importOCIArchive(ss schema.ServiceSchema, meta map[string][]byte) error {
+	index, err := schema.ParseImageIndex(meta[indexJSON[1:]])
+	if err != nil {
+		return err
+	}
+
+	// second pass writes the blobs (each is verified against its digest)
+	err = walkArchive(ss.Archive, func(hdr *tar.Header, r io.Reader) error {
+		name := cleanArchivePath(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(name, blobs[1:]) {
+			return nil
+		}
+		parts := strings.Split(strings.TrimPrefix(name, blobs[1:]), "/")
+		if len(parts) != 2 {
+			return nil
+		}
+		d := schema.Descriptor{Digest: parts[0] + ":" + parts[1], Size: hdr.Size}
+		if layout.New(ss.Path).HasBlob(d) {
+			fmt.Println("INFO: blob exists ", d.Digest)
+			return nil
+		}
+		fmt.Println("INFO: writing blob ", d.Digest)
+		return writeBlobFrom(ss.Path, d, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range index.Manifests {
+		err := layout.New(ss.Path).Walk(m, func(d schema.Descriptor) error {
+			if len(d.URLs) == 0 && !layout.New(ss.Path).HasBlob(d) {
+				return fmt.Errorf("archive is missing blob %s", d.Digest)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ref := m.Annotations[schema.AnnotationRefName]
+		if ref == "" {
+			ref = m.Digest
+			if ss.Image != "" && ss.Version != "" {
+				ref = refName(ss)
+			}
+		}
+		fmt.Println("INFO: importing ", ref)
+		if err := addRef(ss.Path, m, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importDockerArchive(ss schema.ServiceSchema, meta map[string][]byte) error {
+	var dm []DockerArchiveManifest
+	if err := json.Unmarshal(meta[manifestJSON[1:]], &dm); err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, entry := range dm {
+		if meta[cleanArchivePath(entry.Config)] == nil {
+			return fmt.Errorf("archive is missing config %s", entry.Config)
+		}
+		for _, l := range entry.Layers {
+			wanted[cleanArchivePath(l)] = true
+		}
+	}
+
+	// second pass stores each referenced layer gzip compressed
+	type imported struct {
+		desc   schema.Descriptor
+		diffID string
+	}
+	layers := map[string]imported{}
+	links := map[string]string{}
+	err := walkArchive(ss.Archive, func(hdr *tar.Header, r io.Reader) error {
+		name := cleanArchivePath(hdr.Name)
+		if !wanted[name] {
+			return nil
+		}
+		if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+			target := hdr.Linkname
+			if hdr.Typeflag == tar.TypeSymlink {
+				target = filepath.Join(filepath.Dir(name), target)
+			}
+			links[name] = cleanArchivePath(target)
+			return nil
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		fmt.Println("INFO: importing layer ", name)
+		desc, id, err := importDockerLayer(ss.Path, r)
+		if err != nil {
+			return err
+		}
+		layers[name] = imported{desc: desc, diffID: id}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// docker save links identical layers to a single copy
+	for name, target := range links {
+		layers[name] = layers[target]
+	}
+
+	for _, entry := range dm {
+		data := meta[cleanArchivePath(entry.Config)]
+		var config schema.ImageConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return err
+		}
+		if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+			return fmt.Errorf("config %s has %d diff_ids for %d layers", entry.Config, len(config.RootFS.DiffIDs), len(entry.Layers))
+		}
+
+		var ocim = schema.ImageManifest{SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest}
+		for i, l := range entry.Layers {
+			layer, ok := layers[cleanArchivePath(l)]
+			if !ok {
+				return fmt.Errorf("archive is missing layer %s", l)
+			}
+			if layer.diffID != config.RootFS.DiffIDs[i] {
+				return fmt.Errorf("layer %s diff_id %s does not match config %s", l, layer.diffID, config.RootFS.DiffIDs[i])
+			}
+			ocim.Layers = append(ocim.Layers, layer.desc)
+		}
+
+		// the docker config is kept as is, it is a superset of the OCI config
+		ocim.Config, err = writeBlob(ss.Path, schema.MediaTypeImageConfig, data)
+		if err != nil {
+			return err
+		}
+		if err := ocim.Validate(); err != nil {
+			return err
+		}
+		manifest, err := json.Marshal(ocim)
+		if err != nil {
+			return err
+		}
+		desc, err := writeBlob(ss.Path, schema.MediaTypeImageManifest, manifest)
+		if err != nil {
+			return err
+		}
+		fmt.Println("INFO: writing manifest ", desc.Digest)
+
+		refs := entry.RepoTags
+		if len(refs) == 0 {
+			if ss.Image != "" && ss.Version != "" {
+				refs = []string{refName(ss)}
+			} else {
+				refs = []string{ocim.Config.Digest}
+			}
+		}
+		for _, ref := range refs {
+			fmt.Println("INFO: importing ", ref)
+			if err := addRef(ss.Path, desc, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importDockerLayer - stores a docker-archive layer in the layout gzip compressed, returning its descriptor and diff_id
+func importDockerLayer(path string, r io.Reader) (schema.Descriptor, string, error) {
+	tmp, err := ioutil.TempFile(path+blobsPath, ".tmp-")
+	if err != nil {
+		return schema.Descriptor{}, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	var size countingWriter
+	blobHash := sha256.New()
+	diffHash := sha256.New()
+	out := io.MultiWriter(tmp, blobHash, &size)
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		// already compressed, keep the blob as is
+		tee := io.TeeReader(br, out)
+		gz, err := gzip.NewReader(tee)
+		if err != nil {
+			tmp.Close()
+			return schema.Descriptor{}, "", err
+		}
+		if _, err := io.Copy(diffHash, gz); err != nil {
+			tmp.Close()
+			return schema.Descriptor{}, "", err
+		}
+		if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+			tmp.Close()
+			return schema.Descriptor{}, "", err
+		}
+	} else {
+		gw := gzip.NewWriter(out)
+		if _, err := io.Copy(io.MultiWriter(gw, diffHash), br); err != nil {
+			tmp.Close()
+			return schema.Descriptor{}, "", err
+		}
+		if err := gw.Close(); err != nil {
+			tmp.Close()
+			return schema.Descriptor{}, "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return schema.Descriptor{}, "", err
+	}
+
+	desc := schema.Descriptor{
+		MediaType: schema.MediaTypeImageLayerGzip,
+		Digest:    SHA256 + hex.EncodeToString(blobHash.Sum(nil)),
+		Size:      int64(size),
+	}
+	if err := os.Rename(tmp.Name(), layout.New(path).BlobPath(desc.Digest)); err != nil {
+		return schema.Descriptor{}, "", err
+	}
+	return desc, SHA256 + hex.EncodeToString(diffHash.Sum(nil)), nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestImportOCIArchive(t *testing.T) {
+	src := t.TempDir()
+	app := testImage(t, src, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	for _, archive := range []string{"app.tar", "app.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), archive)
+			if err := OCIExportArchive(schema.ServiceSchema{Path: src, Archive: file}); err != nil {
+				t.Fatal(err)
+			}
+			dst := t.TempDir()
+			if err := OCIImportArchive(schema.ServiceSchema{Path: dst, Archive: file}); err != nil {
+				t.Fatal(err)
+			}
+			l := layout.New(dst)
+			d, err := l.Resolve("quay.io/ourorg/app:v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Digest != app.Digest {
+				t.Fatalf("imported %s, exported %s", d.Digest, app.Digest)
+			}
+			if err := l.Walk(d, func(d schema.Descriptor) error {
+				data, err := l.ReadBlob(d.Digest)
+				if err != nil {
+					return err
+				}
+				return schema.VerifyContent(d, data)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestImportDockerArchive(t *testing.T) {
+	src := t.TempDir()
+	testImage(t, src, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	testImage(t, src, "quay.io/ourorg/tool:v2", map[string]string{"bin/tool": "elf"})
+	file := filepath.Join(t.TempDir(), "images.tar")
+	if err := OCIExportArchive(schema.ServiceSchema{Path: src, Archive: file, Format: DockerArchive}); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err := OCIImportArchive(schema.ServiceSchema{Path: dst, Archive: file}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"quay.io/ourorg/app:v1", "quay.io/ourorg/tool:v2"} {
+		want, err := layout.New(src).Resolve(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := layout.New(dst).Resolve(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wm, wc, err := readImage(layout.New(src), want.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gm, gc, err := readImage(layout.New(dst), got.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the layers are recompressed but the config (and with it the diff_ids) is unchanged
+		if gm.Config.Digest != wm.Config.Digest {
+			t.Fatalf("%s config %s, want %s", ref, gm.Config.Digest, wm.Config.Digest)
+		}
+		if len(gc.RootFS.DiffIDs) != len(wc.RootFS.DiffIDs) || len(gm.Layers) != len(gc.RootFS.DiffIDs) {
+			t.Fatalf("%s has %d layers for %d diff_ids", ref, len(gm.Layers), len(gc.RootFS.DiffIDs))
+		}
+	}
+}
+
+func TestImportArchiveErrors(t *testing.T) {
+	src := t.TempDir()
+	app := testImage(t, src, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	m, _, err := readImage(layout.New(src), app.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	complete := filepath.Join(dir, "app.tar")
+	if err := OCIExportArchive(schema.ServiceSchema{Path: src, Archive: complete}); err != nil {
+		t.Fatal(err)
+	}
+	notOCI := filepath.Join(dir, "empty.tar")
+	if err := ioutil.WriteFile(notOCI, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, complete)
+	missing := filepath.Join(dir, "missing.tar")
+	// an archive missing the layer of the image
+	delete(files, "blobs/sha256/"+m.Layers[0].Digest[len(SHA256):])
+	writeArchive(t, missing, files)
+
+	tests := []struct {
+		name    string
+		archive string
+		err     string
+	}{
+		{"missing blob", missing, "archive is missing blob"},
+		{"not an archive", notOCI, "is not an oci-archive or docker-archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OCIImportArchive(schema.ServiceSchema{Path: t.TempDir(), Archive: tt.archive})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("OCIImportArchive error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// writeArchive - writes files (name to content) to a tarball
+func writeArchive(t *testing.T, archive string, files map[string][]byte) {
+	t.Helper()
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
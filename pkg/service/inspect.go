@@ -0,0 +1,287 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIInspect - fetches only the manifest (or index) and config of an image and summarizes them, no layers are downloaded
+func OCIInspect(ss schema.ServiceSchema) (schema.ImageInspect, error) {
+	client, err := newClient(ss, transport.PullScope)
+	if err != nil {
+		return schema.ImageInspect{}, err
+	}
+	data, mediaType, err := fetchManifest(client, ss, ss.Version)
+	if err != nil {
+		return schema.ImageInspect{}, err
+	}
+	ii, err := inspectManifest(&registrySource{client: client, ss: ss}, ss.Platforms, data, mediaType)
+	ii.Name = refName(ss)
+	return ii, err
+}
+
+// OCILayoutInspect - summarizes a ref of the layout at ss.Path, flagging layers missing from the layout
+func OCILayoutInspect(ss schema.ServiceSchema, ref string) (schema.ImageInspect, error) {
+	l := layout.New(ss.Path)
+	src := &layoutSource{layout: l}
+	data, mediaType, err := src.Manifest(ref)
+	if err != nil {
+		return schema.ImageInspect{}, err
+	}
+	ii, err := inspectManifest(src, ss.Platforms, data, mediaType)
+	if err != nil {
+		return ii, err
+	}
+	if d, err := l.Resolve(ref); err == nil {
+		ii.Name = d.Annotations[schema.AnnotationRefName]
+	}
+	flagMissing(l, &ii)
+	return ii, nil
+}
+
+// flagMissing - records the layers of an inspect summary that are not in the layout
+func flagMissing(l *layout.Layout, ii *schema.ImageInspect) {
+	for _, layer := range ii.Layers {
+		if len(layer.URLs) == 0 && !l.HasBlob(layer) {
+			ii.Missing = append(ii.Missing, layer.Digest)
+		}
+	}
+	for i := range ii.Manifests {
+		flagMissing(l, &ii.Manifests[i])
+	}
+}
+
+func inspectManifest(src contentSource, platforms []string, data []byte, mediaType string) (schema.ImageInspect, error) {
+	var ii = schema.ImageInspect{Digest: schema.Digest(data), MediaType: mediaType}
+	switch mediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return ii, err
+		}
+		ii.ArtifactType = index.ArtifactType
+		ii.Annotations = index.Annotations
+		for _, m := range index.Manifests {
+			if !m.Platform.Matches(platforms) {
+				continue
+			}
+			child, childType, err := src.Manifest(m.Digest)
+			if err != nil {
+				return ii, err
+			}
+			ci, err := inspectManifest(src, platforms, child, childType)
+			if err != nil {
+				return ii, err
+			}
+			if m.Platform != nil {
+				ci.Platform = m.Platform.String()
+			}
+			if ci.Size < 0 || ii.Size < 0 {
+				ii.Size = -1
+			} else {
+				ii.Size += ci.Size
+			}
+			ii.Manifests = append(ii.Manifests, ci)
+		}
+		return ii, nil
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		var m schema.ImageManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return ii, err
+		}
+		config, err := src.Blob(m.Config)
+		if err != nil {
+			return ii, err
+		}
+		return summarizeImage(ii, m, config)
+	case schema.MediaTypeDockerManifestV1, schema.MediaTypeDockerManifestV1Signed:
+		var ms schema.ManifestSchema
+		if err := json.Unmarshal(data, &ms); err != nil {
+			return ii, err
+		}
+		if len(ms.History) == 0 {
+			return ii, fmt.Errorf("schemaVersion 1 manifest has no history")
+		}
+		// layer sizes are not part of schemaVersion 1 manifests
+		var m schema.ImageManifest
+		for i := len(ms.FsLayers) - 1; i >= 0; i-- {
+			m.Layers = append(m.Layers, schema.Descriptor{MediaType: schema.MediaTypeDockerLayer, Digest: ms.FsLayers[i].BlobSum, Size: -1})
+		}
+		return summarizeImage(ii, m, []byte(ms.History[0].V1Compatibility))
+	}
+	return ii, fmt.Errorf("unsupported manifest media type %q", mediaType)
+}
+
+// summarizeImage - fills the inspect summary from a manifest and its config
+func summarizeImage(ii schema.ImageInspect, m schema.ImageManifest, config []byte) (schema.ImageInspect, error) {
+	ii.ArtifactType = m.ArtifactType
+	ii.Annotations = m.Annotations
+	ii.Config = m.Config.Digest
+	ii.Layers = m.Layers
+	for _, l := range m.Layers {
+		if l.Size < 0 {
+			ii.Size = -1
+			break
+		}
+		ii.Size += l.Size
+	}
+	// artifacts have configs that are not image configs
+	if m.Config.MediaType != "" && m.Config.MediaType != schema.MediaTypeImageConfig && m.Config.MediaType != schema.MediaTypeDockerConfig {
+		return ii, nil
+	}
+	var cfg schema.ImageConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return ii, err
+	}
+	if cfg.OS != "" && cfg.Architecture != "" {
+		ii.Platform = (&schema.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}).String()
+	}
+	ii.Created = cfg.Created
+	ii.Author = cfg.Author
+	ii.Labels = cfg.Config.Labels
+	ii.Env = cfg.Config.Env
+	ii.Entrypoint = cfg.Config.Entrypoint
+	ii.Cmd = cfg.Config.Cmd
+	ii.User = cfg.Config.User
+	ii.WorkingDir = cfg.Config.WorkingDir
+	return ii, nil
+}
+
+// fetchBlob - reads a (small) blob such as a config from the registry, verifying its digest
+func fetchBlob(client *http.Client, ss schema.ServiceSchema, d schema.Descriptor) ([]byte, error) {
+	if d.Data != nil {
+		return d.Data, schema.VerifyContent(d, d.Data)
+	}
+	req, err := newRequest(ss, http.MethodGet, ss.URL+blobs+d.Digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return data, schema.VerifyContent(d, data)
+}
+
+// PrintInspect - writes the inspect summary as json or human readable text
+func PrintInspect(w io.Writer, ii schema.ImageInspect, output string) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(ii, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	printInspectText(w, ii, "")
+	return nil
+}
+
+func printInspectText(w io.Writer, ii schema.ImageInspect, indent string) {
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(w, "%s%-13s: %v\n", indent, name, value)
+	}
+	list := func(name string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s%-13s:\n", indent, name)
+		for _, v := range values {
+			fmt.Fprintf(w, "%s    %s\n", indent, v)
+		}
+	}
+	mapping := func(name string, values map[string]string) {
+		var keys []string
+		for k, v := range values {
+			keys = append(keys, k+"="+v)
+		}
+		sort.Strings(keys)
+		list(name, keys)
+	}
+
+	if ii.Name != "" {
+		field("Name", ii.Name)
+	}
+	field("Digest", ii.Digest)
+	field("MediaType", ii.MediaType)
+	if ii.ArtifactType != "" {
+		field("ArtifactType", ii.ArtifactType)
+	}
+	if ii.Platform != "" {
+		field("Platform", ii.Platform)
+	}
+	if ii.Created != "" {
+		field("Created", ii.Created)
+	}
+	if ii.Author != "" {
+		field("Author", ii.Author)
+	}
+	if ii.Config != "" {
+		field("Config", ii.Config)
+	}
+	if ii.User != "" {
+		field("User", ii.User)
+	}
+	if ii.WorkingDir != "" {
+		field("WorkingDir", ii.WorkingDir)
+	}
+	if len(ii.Entrypoint) > 0 {
+		field("Entrypoint", strings.Join(ii.Entrypoint, " "))
+	}
+	if len(ii.Cmd) > 0 {
+		field("Cmd", strings.Join(ii.Cmd, " "))
+	}
+	list("Env", ii.Env)
+	mapping("Labels", ii.Labels)
+	mapping("Annotations", ii.Annotations)
+	if len(ii.Layers) > 0 {
+		fmt.Fprintf(w, "%s%-13s:\n", indent, "Layers")
+		for _, l := range ii.Layers {
+			fmt.Fprintf(w, "%s    %s %10s %s\n", indent, l.Digest, humanSize(l.Size), l.MediaType)
+		}
+	}
+	if ii.Size < 0 {
+		field("Size", humanSize(ii.Size))
+	} else {
+		field("Size", fmt.Sprintf("%s (%d bytes compressed)", humanSize(ii.Size), ii.Size))
+	}
+	list("Missing", ii.Missing)
+	for _, m := range ii.Manifests {
+		fmt.Fprintln(w, "")
+		printInspectText(w, m, indent+"    ")
+	}
+}
+
+// humanSize - formats a byte count using binary units
+func humanSize(n int64) string {
+	if n < 0 {
+		return "unknown"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestOCIInspect(t *testing.T) {
+	host := newRegistry(t, 0)
+	var manifests []schema.Descriptor
+	for _, p := range []schema.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}} {
+		config, err := json.Marshal(schema.ImageConfig{
+			Architecture: p.Architecture, OS: p.OS, Variant: p.Variant, Created: "2024-01-02T03:04:05Z",
+			Config: schema.ContainerConfig{Env: []string{"PATH=/bin"}, Entrypoint: []string{"/app"}, Labels: map[string]string{"team": "ours"}},
+			RootFS: schema.RootFS{Type: "layers", DiffIDs: []string{schema.Digest([]byte(p.Architecture))}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the layer is never uploaded, inspect must not need it
+		layer := schema.Descriptor{MediaType: schema.MediaTypeImageLayerGzip, Digest: schema.Digest([]byte(p.Architecture + " layer")), Size: 1000}
+		d := uploadManifest(t, host, "x/app", schema.Digest([]byte(p.Architecture)), schema.MediaTypeImageManifest, schema.ImageManifest{
+			SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest,
+			Config: uploadBlob(t, host, "x/app", schema.MediaTypeImageConfig, config), Layers: []schema.Descriptor{layer},
+		})
+		d.Platform = &schema.Platform{OS: p.OS, Architecture: p.Architecture, Variant: p.Variant}
+		manifests = append(manifests, d)
+	}
+	index := uploadManifest(t, host, "x/app", "v1", schema.MediaTypeImageIndex, schema.ImageIndex{SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex, Manifests: manifests})
+
+	tests := []struct {
+		name      string
+		platforms []string
+		want      []string
+		size      int64
+	}{
+		{"all platforms", nil, []string{"linux/amd64", "linux/arm64/v8"}, 2000},
+		{"one platform", []string{"linux/arm64/v8"}, []string{"linux/arm64/v8"}, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := NewServiceSchema(host+"/x/app", "v1", t.TempDir(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ss.Platforms = tt.platforms
+			ii, err := OCIInspect(ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ii.Digest != index.Digest || ii.MediaType != schema.MediaTypeImageIndex || ii.Size != tt.size {
+				t.Fatalf("inspect %s %s size %d, want %s size %d", ii.MediaType, ii.Digest, ii.Size, index.Digest, tt.size)
+			}
+			var platforms []string
+			for _, m := range ii.Manifests {
+				platforms = append(platforms, m.Platform)
+				if m.Created != "2024-01-02T03:04:05Z" || !reflect.DeepEqual(m.Env, []string{"PATH=/bin"}) ||
+					!reflect.DeepEqual(m.Entrypoint, []string{"/app"}) || m.Labels["team"] != "ours" || len(m.Layers) != 1 {
+					t.Fatalf("inspect of %s = %+v", m.Platform, m)
+				}
+			}
+			if !reflect.DeepEqual(platforms, tt.want) {
+				t.Fatalf("platforms %v, want %v", platforms, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintInspect(t *testing.T) {
+	ii := schema.ImageInspect{
+		Name: "quay.io/ourorg/app:v1", Digest: schema.Digest([]byte("m")), MediaType: schema.MediaTypeImageManifest,
+		Env: []string{"PATH=/bin"}, Labels: map[string]string{"b": "2", "a": "1"}, Size: 3 << 20,
+	}
+	var text bytes.Buffer
+	if err := PrintInspect(&text, ii, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"quay.io/ourorg/app:v1", ii.Digest, "PATH=/bin", "a=1\n    b=2", "3.0 MiB (3145728 bytes compressed)"} {
+		if !strings.Contains(text.String(), want) {
+			t.Fatalf("text output has no %q:\n%s", want, text.String())
+		}
+	}
+	var out bytes.Buffer
+	if err := PrintInspect(&out, ii, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got schema.ImageInspect
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ii) {
+		t.Fatalf("json output %+v, want %+v", got, ii)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{-1, "unknown"},
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := humanSize(tt.n); got != tt.want {
+			t.Errorf("humanSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestOCILayoutInspect(t *testing.T) {
+	path := t.TempDir()
+	d := testImage(t, path, "quay.io/ourorg/app:v1", map[string]string{"etc/app.conf": "debug=false"})
+	l := layout.New(path)
+	m, _, err := readImage(l, d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ii, err := OCILayoutInspect(schema.ServiceSchema{Path: path}, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ii.Name != "quay.io/ourorg/app:v1" || ii.Digest != d.Digest || ii.Platform != "linux/amd64" || ii.Missing != nil {
+		t.Fatalf("OCILayoutInspect = %+v", ii)
+	}
+	if err := os.Remove(l.BlobPath(m.Layers[0].Digest)); err != nil {
+		t.Fatal(err)
+	}
+	ii, err = OCILayoutInspect(schema.ServiceSchema{Path: path}, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ii.Missing, []string{m.Layers[0].Digest}) {
+		t.Fatalf("missing %v, want the removed layer %s", ii.Missing, m.Layers[0].Digest)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCILayoutList - summarizes the refs in the layout at ss.Path and the blobs no ref uses
+func OCILayoutList(ss schema.ServiceSchema) (schema.LayoutSummary, error) {
+	var summary schema.LayoutSummary
+	l := layout.New(ss.Path)
+	refs, err := l.List()
+	if err != nil {
+		return summary, err
+	}
+	summary.Refs = refs
+	summary.Unreferenced, err = l.Unreferenced()
+	return summary, err
+}
+
+// OCILayoutGC - removes the blobs of the layout at ss.Path that no ref uses, ss.DryRun only reports them
+func OCILayoutGC(ss schema.ServiceSchema) error {
+	removed, reclaimed, err := layout.New(ss.Path).GC(ss.DryRun)
+	if err != nil {
+		return err
+	}
+	verb := "removed"
+	if ss.DryRun {
+		verb = "would remove"
+	}
+	for _, digest := range removed {
+		fmt.Println("INFO: "+verb+" ", digest)
+	}
+	fmt.Println("")
+	fmt.Println("INFO: GC summary")
+	fmt.Println("      Dry run    : ", ss.DryRun)
+	fmt.Println("      Blobs      : ", len(removed))
+	fmt.Println("      Reclaimed  : ", humanSize(reclaimed), fmt.Sprintf("(%d bytes)", reclaimed))
+	return nil
+}
+
+// OCILayoutRemove - drops a ref from the layout at ss.Path
+func OCILayoutRemove(ss schema.ServiceSchema, ref string) error {
+	d, err := layout.New(ss.Path).Remove(ref)
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: removed ", ref, d.Digest)
+	fmt.Println("INFO: blobs no longer used are removed by gc")
+	return nil
+}
+
+// OCILayoutTag - adds a new ref for the manifest of src in the layout at ss.Path (push then uses the new name)
+func OCILayoutTag(ss schema.ServiceSchema, src, ref string) error {
+	d, err := layout.New(ss.Path).Tag(src, ref)
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: tagged ", d.Annotations[schema.AnnotationRefName], d.Digest)
+	return nil
+}
+
+// PrintLayoutSummary - writes the layout summary as json or a table
+func PrintLayoutSummary(w io.Writer, summary schema.LayoutSummary, output string) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REF\tDIGEST\tPLATFORMS\tSIZE\t")
+	for _, r := range summary.Refs {
+		ref := r.Ref
+		if ref == "" {
+			ref = "<none>"
+		}
+		platforms := strings.Join(r.Platforms, ",")
+		if platforms == "" {
+			platforms = "-"
+		}
+		size := humanSize(r.Size)
+		if len(r.Missing) > 0 {
+			size += fmt.Sprintf(" (%d blobs missing)", len(r.Missing))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", ref, r.Digest, platforms, size)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range summary.Refs {
+		for _, digest := range r.Missing {
+			fmt.Fprintf(w, "WARN: %s missing blob %s\n", r.Ref, digest)
+		}
+	}
+	for _, digest := range summary.Unreferenced {
+		fmt.Fprintf(w, "WARN: unreferenced blob %s\n", digest)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const defaultConcurrency int = 4
+
+// MirrorOperation - a single image copy resolved from an image set config
+type MirrorOperation struct {
+	Image     string
+	Version   string
+	Platforms []string
+}
+
+// LoadImageSetConfig - reads an image set config file (yaml or json)
+func LoadImageSetConfig(file string) (schema.ImageSetConfig, error) {
+	var cfg schema.ImageSetConfig
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := decodeYAML(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(cfg.Images) == 0 && len(cfg.Catalogs) == 0 && len(cfg.Namespaces) == 0 {
+		return cfg, fmt.Errorf("%s: no images, catalogs or namespaces to mirror", file)
+	}
+	return cfg, nil
+}
+
+// OCIMirrorImageSet - mirrors everything listed in the image set config (ss.Config) into the layout
+// the destination in the config is used unless ss.Path is set
+func OCIMirrorImageSet(ss schema.ServiceSchema) error {
+	start := time.Now()
+	cfg, err := LoadImageSetConfig(ss.Config)
+	if err != nil {
+		return err
+	}
+	if ss.Path == "" {
+		ss.Path = cfg.Destination
+	}
+	if ss.Path == "" {
+		return fmt.Errorf("no destination set in %s", ss.Config)
+	}
+	if cfg.TLSVerify != nil {
+		ss.TLS = *cfg.TLSVerify
+	}
+	ss.Auth = ss.Auth || cfg.BasicAuth
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	ops, err := PlanImageSet(cfg, ss)
+	if err != nil {
+		return err
+	}
+	return runMirror(ss, ops, concurrency, start)
+}
+
+// runMirror - executes the copy operations with a shared pool of workers and prints a summary
+func runMirror(ss schema.ServiceSchema, ops []MirrorOperation, concurrency int, start time.Time) error {
+	fmt.Printf("INFO: mirroring %d images to %s (concurrency %d)\n", len(ops), ss.Path, concurrency)
+
+	blobsBefore, bytesBefore := atomic.LoadInt64(&blobsWritten), atomic.LoadInt64(&bytesWritten)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := map[string]error{}
+	jobs := make(chan MirrorOperation)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range jobs {
+				rs, err := NewServiceSchema(op.Image, op.Version, ss.Path, ss.TLS, ss.Auth)
+				if err == nil {
+					rs.Platforms = op.Platforms
+					rs.Recompress = ss.Recompress
+					rs.Signatures = ss.Signatures
+					rs.Artifacts = ss.Artifacts
+					err = OCICopyToDisk(rs)
+				}
+				if err != nil {
+					mu.Lock()
+					failed[op.Image+":"+op.Version] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, op := range ops {
+		jobs <- op
+	}
+	close(jobs)
+	wg.Wait()
+
+	fmt.Println("")
+	fmt.Println("INFO: Mirror summary")
+	fmt.Println("      Planned    : ", len(ops))
+	fmt.Println("      Copied     : ", len(ops)-len(failed))
+	fmt.Println("      Failed     : ", len(failed))
+	fmt.Println("      Blobs      : ", atomic.LoadInt64(&blobsWritten)-blobsBefore)
+	fmt.Println("      Bytes      : ", atomic.LoadInt64(&bytesWritten)-bytesBefore)
+	fmt.Println("      Duration   : ", time.Since(start).Round(time.Second))
+	var names []string
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(fmt.Sprintf("ERROR: %s %v", name, failed[name]))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to mirror %d of %d images", len(failed), len(ops))
+	}
+	return nil
+}
+
+// PlanImageSet - resolves the config into concrete copy operations, deduplicated across images, namespaces and catalogs
+// catalog images are copied while planning as their file-based catalog is needed to find the related images
+func PlanImageSet(cfg schema.ImageSetConfig, ss schema.ServiceSchema) ([]MirrorOperation, error) {
+	var ops []MirrorOperation
+	seen := map[string]int{}
+	add := func(image, version string, platforms []string) {
+		key := image + "@" + version
+		if i, ok := seen[key]; ok {
+			// an empty platform list means all platforms
+			if len(ops[i].Platforms) > 0 && len(platforms) > 0 {
+				ops[i].Platforms = mergeStrings(ops[i].Platforms, platforms)
+			} else {
+				ops[i].Platforms = nil
+			}
+			return
+		}
+		seen[key] = len(ops)
+		ops = append(ops, MirrorOperation{Image: image, Version: version, Platforms: platforms})
+	}
+
+	for _, entry := range cfg.Images {
+		image, version := SplitReference(entry.Name)
+		// a copy, appending must not write to the backing array of the config
+		tags := append([]string(nil), entry.Tags...)
+		if image != entry.Name {
+			tags = append(tags, version)
+		}
+		if entry.TagFilter.IsSet() {
+			rs, err := NewServiceSchema(image, "", ss.Path, ss.TLS, ss.Auth)
+			if err != nil {
+				return nil, err
+			}
+			rs.Tags = entry.TagFilter
+			matched, err := OCIListTags(rs)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, matched...)
+		} else if len(tags) == 0 {
+			tags = []string{version}
+		}
+		for _, tag := range tags {
+			add(image, tag, entry.Platforms)
+		}
+	}
+
+	for _, entry := range cfg.Namespaces {
+		ns, err := NewNamespaceSchema(entry.Name, ss.Path, ss.TLS, ss.Auth)
+		if err != nil {
+			return nil, err
+		}
+		ns.Tags = entry.TagFilter
+		fmt.Println("INFO: resolving namespace ", entry.Name)
+		found, err := planNamespace(ns, entry.Include, entry.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		for _, op := range found {
+			add(op.Image, op.Version, entry.Platforms)
+		}
+	}
+
+	for _, entry := range cfg.Catalogs {
+		image, version := SplitReference(entry.Name)
+		cs, err := NewServiceSchema(image, version, ss.Path, ss.TLS, ss.Auth)
+		if err != nil {
+			return nil, err
+		}
+		cs.Packages = entry.Packages
+		cs.Channel = entry.Channel
+		cs.Versions = entry.Versions
+		cs.Platforms = entry.Platforms
+		cs.Recompress = ss.Recompress
+		cs.Signatures = ss.Signatures
+		cs.Artifacts = ss.Artifacts
+		fmt.Println("INFO: resolving catalog ", entry.Name)
+		images, err := CatalogRelatedImages(cs)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			image, version := SplitReference(img)
+			add(image, version, entry.Platforms)
+		}
+	}
+	return ops, nil
+}
+
+// mergeStrings - union of two lists keeping the order of first appearance
+func mergeStrings(a, b []string) []string {
+	seen := map[string]bool{}
+	var merged []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[strings.TrimSpace(s)] {
+			seen[strings.TrimSpace(s)] = true
+			merged = append(merged, strings.TrimSpace(s))
+		}
+	}
+	return merged
+}
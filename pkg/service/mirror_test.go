@@ -0,0 +1,54 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestPlanImageSetVerifiesCatalog(t *testing.T) {
+	host := newRegistry(t, 0)
+	dockerImage(t, host, "x/catalog", "v1")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := schema.ImageSetConfig{Catalogs: []schema.ImageSetCatalog{{Name: host + "/x/catalog:v1"}}}
+	ss := schema.ServiceSchema{Path: t.TempDir(), Signatures: schema.SignaturePolicy{Keys: []string{publicKeyFile(t, key.Public())}}}
+	_, err = PlanImageSet(cfg, ss)
+	if err == nil || !strings.Contains(err.Error(), "has no signatures") {
+		t.Fatalf("PlanImageSet error %v, want the unsigned catalog to be rejected", err)
+	}
+}
+
+func TestPlanImageSetImages(t *testing.T) {
+	// entries sharing a backing array with room to append, as yaml decoding can leave them
+	shared := make([]string, 1, 4)
+	shared[0] = "v1"
+	cfg := schema.ImageSetConfig{Images: []schema.ImageSetImage{
+		{Name: "quay.io/ourorg/app:v2", Tags: shared, Platforms: []string{"linux/amd64"}},
+		{Name: "quay.io/ourorg/tool:v3", Tags: shared},
+		{Name: "quay.io/ourorg/app:v1", Platforms: []string{"linux/arm64"}},
+	}}
+	ops, err := PlanImageSet(cfg, schema.ServiceSchema{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MirrorOperation{
+		{Image: "quay.io/ourorg/app", Version: "v1", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		{Image: "quay.io/ourorg/app", Version: "v2", Platforms: []string{"linux/amd64"}},
+		{Image: "quay.io/ourorg/tool", Version: "v1"},
+		{Image: "quay.io/ourorg/tool", Version: "v3"},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("PlanImageSet = %+v, want %+v", ops, want)
+	}
+	if extra := shared[:cap(shared)][1]; extra != "" {
+		t.Fatalf("PlanImageSet appended %q to the tags of the config", extra)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// docker buildx adds attestation manifests to an index, they describe the image of their vnd.docker.reference.digest
+const (
+	referenceTypeAnnotation   string = "vnd.docker.reference.type"
+	referenceDigestAnnotation string = "vnd.docker.reference.digest"
+)
+
+var portRegex = regexp.MustCompile(`^[0-9]{1,5}(/(tcp|udp|sctp))?$`)
+
+// OCIMutate - applies ss.Edits to the config of ref in the layout at ss.Path and points ref at the new image
+// every image of a multi-arch index is changed and a new index is written for it
+func OCIMutate(ss schema.ServiceSchema, ref string) error {
+	if !ss.Edits.IsSet() {
+		return fmt.Errorf("no changes to make to %s", ref)
+	}
+	l := layout.New(ss.Path)
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	name := d.Annotations[schema.AnnotationRefName]
+	if name == "" {
+		return fmt.Errorf("ref %s has no name in %s", ref, layout.IndexFile)
+	}
+	desc, err := rewriteImages(l, d, ss.Edits.Annotations, func(m *schema.ImageManifest, config *schema.ImageConfig) error {
+		if err := applyEdits(&config.Config, ss.Edits); err != nil {
+			return err
+		}
+		var err error
+		m.Annotations, err = setKeyValues(m.Annotations, ss.Edits.Annotations)
+		config.History = append(config.History, schema.HistorySchema{
+			Created:    time.Now().UTC().Format(time.RFC3339),
+			CreatedBy:  "oci mutate",
+			Comment:    describeEdits(ss.Edits),
+			EmptyLayer: true,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	desc.Annotations = d.Annotations
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	return l.AddRef(desc, name)
+}
+
+// imageEdit - changes the manifest and config of one image
+type imageEdit func(m *schema.ImageManifest, config *schema.ImageConfig) error
+
+// rewriteImages - writes a copy of the image (or of every image of an index) changed by edit
+// annotations (KEY=VALUE or a bare KEY to remove it) are also set on a rewritten index
+func rewriteImages(l *layout.Layout, d schema.Descriptor, annotations []string, edit imageEdit) (schema.Descriptor, error) {
+	switch d.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		data, err := l.ReadBlob(d.Digest)
+		if err != nil {
+			return d, err
+		}
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return d, err
+		}
+		manifests := []schema.Descriptor{}
+		for _, m := range index.Manifests {
+			// the attestations (provenance, sbom) were made for the image as built, not for the rewritten one
+			if m.Annotations[referenceTypeAnnotation] != "" {
+				fmt.Println("INFO: dropping attestation ", m.Digest, " of ", m.Annotations[referenceDigestAnnotation])
+				continue
+			}
+			child, err := rewriteImages(l, m, annotations, edit)
+			if err != nil {
+				return d, err
+			}
+			child.Platform, child.Annotations = m.Platform, m.Annotations
+			manifests = append(manifests, child)
+		}
+		index.Manifests = manifests
+		if index.Annotations, err = setKeyValues(index.Annotations, annotations); err != nil {
+			return d, err
+		}
+		// the images are now oci manifests
+		index.SchemaVersion = 2
+		index.MediaType = schema.MediaTypeImageIndex
+		if err := index.Validate(); err != nil {
+			return d, err
+		}
+		data, err = json.Marshal(index)
+		if err != nil {
+			return d, err
+		}
+		return writeBlob(l.Path, schema.MediaTypeImageIndex, data)
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		m, config, err := readImage(l, d.Digest)
+		if err != nil {
+			return d, err
+		}
+		if err := edit(&m, &config); err != nil {
+			return d, err
+		}
+		config.Created = time.Now().UTC().Format(time.RFC3339)
+		desc, err := writeImage(l.Path, m, config)
+		if err != nil {
+			return desc, err
+		}
+		fmt.Println("INFO: rewrote ", d.Digest, " as ", desc.Digest)
+		return desc, nil
+	}
+	return d, fmt.Errorf("unsupported manifest media type %q", d.MediaType)
+}
+
+// applyEdits - changes the execution parameters of a config
+func applyEdits(c *schema.ContainerConfig, e schema.ConfigEdits) error {
+	for _, kv := range e.Env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if key == "" {
+			return fmt.Errorf("env %q has no name", kv)
+		}
+		var env []string
+		var found bool
+		for _, v := range c.Env {
+			if strings.SplitN(v, "=", 2)[0] != key {
+				env = append(env, v)
+				continue
+			}
+			// replaced in place so the order of the other variables is kept
+			if strings.Contains(kv, "=") && !found {
+				env = append(env, kv)
+			}
+			found = true
+		}
+		if !found && strings.Contains(kv, "=") {
+			env = append(env, kv)
+		}
+		c.Env = env
+	}
+	var err error
+	if c.Labels, err = setKeyValues(c.Labels, e.Labels); err != nil {
+		return err
+	}
+	for _, port := range e.ExposedPorts {
+		if !portRegex.MatchString(port) {
+			return fmt.Errorf("exposed port %q is not port[/tcp|udp|sctp]", port)
+		}
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = map[string]struct{}{}
+		}
+		c.ExposedPorts[port] = struct{}{}
+	}
+	if e.Entrypoint != nil {
+		c.Entrypoint = e.Entrypoint
+	}
+	if e.Cmd != nil {
+		c.Cmd = e.Cmd
+	}
+	if e.User != nil {
+		c.User = *e.User
+	}
+	if e.WorkingDir != nil {
+		c.WorkingDir = *e.WorkingDir
+	}
+	return nil
+}
+
+// setKeyValues - sets (KEY=VALUE) or removes (a bare KEY) entries of labels or annotations
+func setKeyValues(m map[string]string, kvs []string) (map[string]string, error) {
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if parts[0] == "" {
+			return m, fmt.Errorf("%q has no key", kv)
+		}
+		if len(parts) == 1 {
+			delete(m, parts[0])
+			continue
+		}
+		if m == nil {
+			m = map[string]string{}
+		}
+		m[parts[0]] = parts[1]
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// describeEdits - the history comment of a mutate
+func describeEdits(e schema.ConfigEdits) string {
+	var changed []string
+	for name, set := range map[string]bool{
+		"env":         len(e.Env) > 0,
+		"labels":      len(e.Labels) > 0,
+		"annotations": len(e.Annotations) > 0,
+		"ports":       len(e.ExposedPorts) > 0,
+		"entrypoint":  e.Entrypoint != nil,
+		"cmd":         e.Cmd != nil,
+		"user":        e.User != nil,
+		"workdir":     e.WorkingDir != nil,
+	} {
+		if set {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return "changed " + strings.Join(changed, ", ")
+}
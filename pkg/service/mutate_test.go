@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestApplyEdits(t *testing.T) {
+	user := "1001"
+	tests := []struct {
+		name  string
+		edits schema.ConfigEdits
+		want  schema.ContainerConfig
+		err   string
+	}{
+		{
+			"env replaced in place and removed",
+			schema.ConfigEdits{Env: []string{"A=2", "C"}},
+			schema.ContainerConfig{Env: []string{"A=2", "B=1"}, Labels: map[string]string{"team": "theirs"}},
+			"",
+		},
+		{
+			"labels, ports and user",
+			schema.ConfigEdits{Labels: []string{"team=ours", "new=x"}, ExposedPorts: []string{"8080", "53/udp"}, User: &user},
+			schema.ContainerConfig{
+				Env:          []string{"A=1", "B=1", "C=1"},
+				Labels:       map[string]string{"team": "ours", "new": "x"},
+				ExposedPorts: map[string]struct{}{"8080/tcp": {}, "53/udp": {}},
+				User:         "1001",
+			},
+			"",
+		},
+		{"env without a name", schema.ConfigEdits{Env: []string{"=1"}}, schema.ContainerConfig{}, "has no name"},
+		{"bad port", schema.ConfigEdits{ExposedPorts: []string{"http"}}, schema.ContainerConfig{}, "is not port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := schema.ContainerConfig{Env: []string{"A=1", "B=1", "C=1"}, Labels: map[string]string{"team": "theirs"}}
+			err := applyEdits(&c, tt.edits)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("applyEdits error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c, tt.want) {
+				t.Fatalf("applyEdits = %+v, want %+v", c, tt.want)
+			}
+		})
+	}
+}
+
+func TestOCIMutateIndex(t *testing.T) {
+	path := t.TempDir()
+	l := layout.New(path)
+	amd := testImage(t, path, "quay.io/ourorg/app:amd64", map[string]string{"bin/app": "amd64"})
+	arm := testImage(t, path, "quay.io/ourorg/app:arm64", map[string]string{"bin/app": "arm64"})
+	// the provenance docker buildx adds for the amd64 image
+	attestation, err := writeBlob(path, schema.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"mediaType":"`+schema.MediaTypeImageManifest+`",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"`+schema.Digest([]byte("{}"))+`","size":2},"layers":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation.Annotations = map[string]string{referenceTypeAnnotation: "attestation-manifest", referenceDigestAnnotation: amd.Digest}
+	attestation.Platform = &schema.Platform{Architecture: "unknown", OS: "unknown"}
+	amd.Platform = &schema.Platform{Architecture: "amd64", OS: "linux"}
+	arm.Platform = &schema.Platform{Architecture: "arm64", OS: "linux", Variant: "v8"}
+	data, err := json.Marshal(schema.ImageIndex{SchemaVersion: 2, MediaType: schema.MediaTypeImageIndex, Manifests: []schema.Descriptor{amd, arm, attestation}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := writeBlob(path, schema.MediaTypeImageIndex, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AddRef(index, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := schema.ServiceSchema{Path: path, Edits: schema.ConfigEdits{Env: []string{"LOG_LEVEL=debug"}, Annotations: []string{"org.example.team=ours"}}}
+	if err := OCIMutate(ss, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := l.Resolve("quay.io/ourorg/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = l.ReadBlob(d.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten, err := schema.ParseImageIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten.Annotations["org.example.team"] != "ours" {
+		t.Fatalf("index annotations %v", rewritten.Annotations)
+	}
+	// the attestation of the old amd64 image is dropped
+	if len(rewritten.Manifests) != 2 {
+		t.Fatalf("index has %d manifests, want 2", len(rewritten.Manifests))
+	}
+	for i, old := range []schema.Descriptor{amd, arm} {
+		m := rewritten.Manifests[i]
+		if m.Digest == old.Digest || !reflect.DeepEqual(m.Platform, old.Platform) {
+			t.Fatalf("manifest %d is %s for %+v, want a new image for %+v", i, m.Digest, m.Platform, old.Platform)
+		}
+		_, config, err := readImage(l, m.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(config.Config.Env, []string{"LOG_LEVEL=debug"}) {
+			t.Fatalf("manifest %d env %v", i, config.Config.Env)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+const (
+	catalogPath  string = "_catalog"
+	catalogScope string = "registry:catalog:*"
+)
+
+// NewNamespaceSchema - sets up the service schema for a registry namespace (registry[/prefix])
+// the URL is the registry api base and User holds the repository prefix
+func NewNamespaceSchema(namespace, path string, tls, auth bool) (schema.ServiceSchema, error) {
+	registry, prefix := namespace, ""
+	if i := strings.Index(namespace, "/"); i >= 0 {
+		registry, prefix = namespace[:i], strings.Trim(namespace[i+1:], "/")
+	}
+	var ss = schema.ServiceSchema{Image: namespace, Path: path, TLS: tls, Auth: auth}
+	var opts []name.Option
+	if !tls {
+		opts = append(opts, name.Insecure)
+	}
+	reg, err := name.NewRegistry(registry, opts...)
+	if err != nil {
+		return ss, err
+	}
+	ss.Name = reg.RegistryStr()
+	ss.User = prefix
+	scheme := "https://"
+	if !tls {
+		scheme = "http://"
+	}
+	ss.URL = scheme + ss.Name + apiVersion
+	return ss, nil
+}
+
+// ListRepositories - walks the (paginated) /v2/_catalog of the registry, keeping the repositories below the prefix in ss.User
+func ListRepositories(ss schema.ServiceSchema) ([]string, error) {
+	var opts []name.Option
+	if !ss.TLS {
+		opts = append(opts, name.Insecure)
+	}
+	reg, err := name.NewRegistry(ss.Name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := authn.DefaultKeychain.Resolve(reg)
+	if err != nil {
+		return nil, err
+	}
+	t, err := transport.New(reg, auth, http.DefaultTransport, []string{catalogScope})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: t}
+
+	var repos []string
+	next := fmt.Sprintf("%s%s?n=%d", ss.URL, catalogPath, tagsPageSize)
+	for next != "" {
+		req, err := newRequest(ss, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var rl schema.RepositoryList
+		err = json.NewDecoder(resp.Body).Decode(&rl)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range rl.Repositories {
+			if ss.User == "" || repo == ss.User || strings.HasPrefix(repo, ss.User+"/") {
+				repos = append(repos, repo)
+			}
+		}
+		next, err = nextLink(next, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
+
+// FilterRepositories - keeps the repositories matching any include glob (all if none) and no exclude glob
+// globs with a / match the full repository name, the others its last element (* does not match /)
+func FilterRepositories(repos, include, exclude []string) ([]string, error) {
+	var filtered []string
+	for _, repo := range repos {
+		ok := len(include) == 0
+		for _, g := range include {
+			m, err := matchRepository(g, repo)
+			if err != nil {
+				return nil, err
+			}
+			ok = ok || m
+		}
+		for _, g := range exclude {
+			m, err := matchRepository(g, repo)
+			if err != nil {
+				return nil, err
+			}
+			ok = ok && !m
+		}
+		if ok {
+			filtered = append(filtered, repo)
+		}
+	}
+	return filtered, nil
+}
+
+// matchRepository - matches a glob against the repository name, or only its last element when the glob has no /
+func matchRepository(glob, repo string) (bool, error) {
+	if !strings.Contains(glob, "/") {
+		repo = path.Base(repo)
+	}
+	return path.Match(glob, repo)
+}
+
+// OCIMirrorNamespace - copies every repository of a registry namespace into the layout
+// ss.Version copies a single tag of each repository, otherwise the tag filters (or all tags) are used
+// with ss.DryRun the repositories and tags are only listed
+func OCIMirrorNamespace(ss schema.ServiceSchema) error {
+	start := time.Now()
+	ops, err := planNamespace(ss, ss.Include, ss.Exclude)
+	if err != nil {
+		return err
+	}
+	if ss.DryRun {
+		for _, op := range ops {
+			fmt.Println(op.Image + ":" + op.Version)
+		}
+		fmt.Printf("INFO: dry run, %d images would be copied\n", len(ops))
+		return nil
+	}
+	return runMirror(ss, ops, defaultConcurrency, start)
+}
+
+// planNamespace - resolves the repositories (and their tags) of a namespace into copy operations
+func planNamespace(ss schema.ServiceSchema, include, exclude []string) ([]MirrorOperation, error) {
+	if ss.Version != "" && ss.Tags.IsSet() {
+		return nil, fmt.Errorf("a version (-v) can't be combined with tag filters")
+	}
+	repos, err := ListRepositories(ss)
+	if err != nil {
+		return nil, err
+	}
+	repos, err = FilterRepositories(repos, include, exclude)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("INFO: found %d repositories in %s\n", len(repos), ss.Image)
+
+	registry := strings.Split(ss.Image, "/")[0]
+	var ops []MirrorOperation
+	for _, repo := range repos {
+		rs, err := NewServiceSchema(registry+"/"+repo, ss.Version, ss.Path, ss.TLS, ss.Auth)
+		if err != nil {
+			return nil, err
+		}
+		tags := []string{ss.Version}
+		if ss.Version == "" {
+			rs.Tags = ss.Tags
+			tags, err = OCIListTags(rs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, tag := range tags {
+			ops = append(ops, MirrorOperation{Image: rs.Image, Version: tag, Platforms: ss.Platforms})
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterRepositories(t *testing.T) {
+	repos := []string{"ourorg/app", "ourorg/app-test", "ourorg/tools/cli", "ourorg/tools/cli-test", "ourorg/web"}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+		err     bool
+	}{
+		{"no filters", nil, nil, repos, false},
+		{"include full name", []string{"ourorg/app*"}, nil, []string{"ourorg/app", "ourorg/app-test"}, false},
+		{"include does not cross /", []string{"ourorg/*"}, nil, []string{"ourorg/app", "ourorg/app-test", "ourorg/web"}, false},
+		{"include last element", []string{"cli*"}, nil, []string{"ourorg/tools/cli", "ourorg/tools/cli-test"}, false},
+		{"exclude last element", nil, []string{"*-test"}, []string{"ourorg/app", "ourorg/tools/cli", "ourorg/web"}, false},
+		{"exclude full name", nil, []string{"ourorg/tools/*"}, []string{"ourorg/app", "ourorg/app-test", "ourorg/web"}, false},
+		{"include and exclude", []string{"ourorg/app*"}, []string{"*-test"}, []string{"ourorg/app"}, false},
+		{"nothing matches", []string{"other/*"}, nil, nil, false},
+		{"bad glob", []string{"ourorg/[app"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterRepositories(repos, tt.include, tt.exclude)
+			if (err != nil) != tt.err {
+				t.Fatalf("FilterRepositories error %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FilterRepositories = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,196 +2,196 @@ package service
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
 	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
 )
 
 // OCIPushToRegistry - pushes a local OCI image to remote registry
+// its cosign signatures in the layout are pushed after it, ss.Artifacts also pushes the attestations and SBOMs stored with it
 func OCIPushToRegistry(ss schema.ServiceSchema) error {
 
-	var ba *schema.BasicAuth
-	var ociManifest = &schema.OCIImageManifest{SchemaVersion: 2}
-	var layers []schema.Layer
-
-	repo, err := name.NewRepository(ss.Image)
+	client, err := newClient(ss, transport.PushScope)
 	if err != nil {
 		return err
 	}
 
-	// Fetch credentials based on your docker config file, which is $HOME/.docker/config.json or $DOCKER_CONFIG.
-	auth, err := authn.DefaultKeychain.Resolve(repo.Registry)
+	// find the image to push in index.json
+	desc, err := resolveRef(layout.New(ss.Path), ss)
 	if err != nil {
 		return err
 	}
+	// the recompressed layers are written to the layout, the ref in index.json is left as is
+	stored := desc
+	if ss.Recompress != "" {
+		release, err := layout.New(ss.Path).RLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+		if desc, err = recompress(ss.Path, desc, ss.Recompress); err != nil {
+			return err
+		}
+	}
 
-	// Construct an http.Client that is authorized to push
-	scopes := []string{repo.Scope(transport.PushScope)}
-	t, err := transport.New(repo.Registry, auth, http.DefaultTransport, scopes)
-	if err != nil {
+	if err := pushManifest(client, ss, desc, ss.Version); err != nil {
 		return err
 	}
-	client := &http.Client{Transport: t}
+	if desc.Digest != stored.Digest {
+		fmt.Println("WARN: the image was recompressed, signatures and artifacts of ", stored.Digest, " are not pushed")
+	}
+	if ss.Artifacts {
+		return pushArtifacts(client, ss, desc)
+	}
+	// signatures (made by sign or copied with the image) are always published with it
+	return pushSignatures(client, ss, desc)
+}
 
-	// read the index.json
-	var index = schema.Index{}
-	data, err := ioutil.ReadFile(ss.Path + indexJSON)
+// pushManifest - pushes all content referenced by a manifest (or index) and then the manifest itself
+func pushManifest(client *http.Client, ss schema.ServiceSchema, desc schema.Descriptor, reference string) error {
+	data, err := layout.New(ss.Path).ReadBlob(desc.Digest)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, &index)
-
-	// read the blobs
-	files, err := ioutil.ReadDir(ss.Path + blobsPath)
-	if err != nil {
+	if err := schema.VerifyContent(desc, data); err != nil {
 		return err
 	}
 
-	var errs []error
-	ch := make(chan byte, 1)
-	for _, file := range files {
-
-		// launch each layer push concurrently
-		go func(file string) {
-
-			// set up the request
-			req, err := http.NewRequest(http.MethodPost, ss.URL+blobs+uploads, nil)
-			if ss.Auth {
-				ba, _ = GetBasicAuthCredentials()
-				req.SetBasicAuth(ba.User, ba.Password)
-			}
-			if err != nil {
-				errs = append(errs, err)
+	switch desc.MediaType {
+	case schema.MediaTypeImageIndex, schema.MediaTypeDockerManifestList:
+		index, err := schema.ParseImageIndex(data)
+		if err != nil && desc.MediaType == schema.MediaTypeImageIndex {
+			return err
+		}
+		for _, m := range index.Manifests {
+			if err := pushManifest(client, ss, m, m.Digest); err != nil {
+				return err
 			}
-			resp, err := client.Do(req)
-			if err != nil {
-				errs = append(errs, err)
+		}
+	case schema.MediaTypeImageManifest, schema.MediaTypeDockerManifest:
+		ocim, err := schema.ParseImageManifest(data)
+		if err != nil && desc.MediaType == schema.MediaTypeImageManifest {
+			return err
+		}
+		if err := pushBlob(client, ss, ocim.Config); err != nil {
+			return err
+		}
+		for _, l := range ocim.Layers {
+			if len(l.URLs) > 0 && !layout.New(ss.Path).HasBlob(l) {
+				fmt.Println("INFO: skipping non distributable layer ", l.Digest)
+				continue
 			}
-			defer resp.Body.Close()
-			uuid := resp.Header.Get("Docker-Upload-UUID")
-			location, err := url.QueryUnescape(resp.Header.Get("Location"))
-			if err != nil {
-				errs = append(errs, err)
+			if err := pushBlob(client, ss, l); err != nil {
+				return err
 			}
-			fmt.Println("INFO: POST response: " + resp.Status)
-			fmt.Println("INFO: POST location: ", location)
-			fmt.Println("INFO: POST uuid: ", uuid)
+		}
+	default:
+		return fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+	}
 
-			data, err := ioutil.ReadFile(ss.Path + blobsPath + file)
-			if err != nil {
-				errs = append(errs, err)
-			}
+	return putManifest(client, ss, data, desc.MediaType, reference)
+}
 
-			// get ths sha256 digest for each layer
-			h := sha256.New()
-			if _, err := io.Copy(h, bytes.NewBuffer(data)); err != nil {
-				errs = append(errs, err)
-			}
+// putManifest - uploads a manifest (or index) as reference, its content must be in the registry already
+func putManifest(client *http.Client, ss schema.ServiceSchema, data []byte, mediaType, reference string) error {
+	req, err := newRequest(ss, http.MethodPut, ss.URL+manifests+reference, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	fmt.Println("INFO: PUT manifest response: " + resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		d, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("response from manifest upload %d %s", resp.StatusCode, string(d))
+	}
+	return nil
+}
 
-			// we now have the digest
-			digestID := h.Sum(nil)
-			fmt.Println("INFO: POST digestID: ", hex.EncodeToString(digestID))
-			manifest := index.Manifests[0].Digest[7:]
+// pushBlob - uploads a blob from the layout (monolithic upload) unless the registry already has it
+func pushBlob(client *http.Client, ss schema.ServiceSchema, d schema.Descriptor) error {
 
-			// check to see if the blob exists
-			req, err = http.NewRequest(http.MethodHead, ss.URL+blobs+hex.EncodeToString(digestID), nil)
-			if ss.Auth {
-				req.SetBasicAuth(ba.User, ba.Password)
-			}
-			if err != nil {
-				errs = append(errs, err)
-			}
-			resp, err = client.Do(req)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			defer resp.Body.Close()
-			fmt.Println("INFO: HEAD response: " + resp.Status)
-
-			if resp.StatusCode != http.StatusOK {
-				url := location + "&digest=" + SHA256 + hex.EncodeToString(digestID)
-				fmt.Println("INFO: Uploading blob ", hex.EncodeToString(digestID))
-				reqUpload, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
-				if err != nil {
-					errs = append(errs, err)
-				}
-				if ss.Auth {
-					reqUpload.SetBasicAuth(ba.User, ba.Password)
-				}
-				reqUpload.Header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
-				reqUpload.Header.Set("Content-Type", "application/octet-stream")
-				respUpload, err := client.Do(reqUpload)
-				if err != nil {
-					errs = append(errs, err)
-				}
-				defer respUpload.Body.Close()
-				fmt.Println("INFO: PUT upload response: " + respUpload.Status)
-				if respUpload.StatusCode <= 200 || respUpload.StatusCode >= 300 {
-					errs = append(errs, fmt.Errorf("response from data upload %d", respUpload.StatusCode))
-				}
-				// all good we append to layer struct and update config
-				if manifest == file {
-					ociManifest.Config.MediaType = "application/vnd.oci.image.config.v1+json"
-					ociManifest.Config.Digest = SHA256 + hex.EncodeToString(digestID)
-					ociManifest.Config.Size = len(data)
-
-				} else {
-					layer := schema.Layer{Digest: SHA256 + hex.EncodeToString(digestID), MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Size: len(data)}
-					layers = append(layers, layer)
-				}
-			}
-			ch <- 1
-		}(file.Name())
-		<-ch
+	// check to see if the blob exists
+	req, err := newRequest(ss, http.MethodHead, ss.URL+blobs+d.Digest, nil)
+	if err != nil {
+		return err
 	}
-
-	// iterate throigh errors
-	for _, e := range errs {
-		if e != nil {
-			return e
-		}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	fmt.Println("INFO: HEAD response: " + resp.Status)
+	if resp.StatusCode == http.StatusOK {
+		fmt.Println("INFO: blob exists ", d.Digest)
+		return nil
 	}
 
-	// update the index.json file
-	// put the manifest for the given image
-	// set the HTTP method, url, and request body
-	ociManifest.Layers = layers
-	jsonOCIManifest, err := json.Marshal(ociManifest)
+	// start the upload session
+	req, err = newRequest(ss, http.MethodPost, ss.URL+blobs+uploads, nil)
+	if err != nil {
+		return err
+	}
+	resp, err = client.Do(req)
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
+	fmt.Println("INFO: POST response: " + resp.Status)
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("response from upload session %d", resp.StatusCode)
+	}
 
-	// set up the request
-	req, err := http.NewRequest(http.MethodPut, ss.URL+manifests+ss.Version, bytes.NewBuffer(jsonOCIManifest))
-	fmt.Println("DEBUG LMZ : ", string(jsonOCIManifest))
-	req.Header.Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
-	if ss.Auth {
-		req.SetBasicAuth(ba.User, ba.Password)
+	// the location may be relative to the registry url
+	base, err := url.Parse(ss.URL)
+	if err != nil {
+		return err
 	}
+	location, err := url.Parse(resp.Header.Get("Location"))
 	if err != nil {
 		return err
 	}
+	location = base.ResolveReference(location)
+	q := location.Query()
+	q.Set("digest", d.Digest)
+	location.RawQuery = q.Encode()
+	fmt.Println("INFO: POST location: ", location.String())
 
-	// set the request header Content-Type for json
-	resp, err := client.Do(req)
-	d, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println("INFO: " + resp.Status)
+	f, err := os.Open(layout.New(ss.Path).BlobPath(d.Digest))
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode <= 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf(string(d))
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
 	}
 
+	fmt.Println("INFO: Uploading blob ", d.Digest)
+	reqUpload, err := newRequest(ss, http.MethodPut, location.String(), f)
+	if err != nil {
+		return err
+	}
+	reqUpload.ContentLength = fi.Size()
+	reqUpload.Header.Set("Content-Type", "application/octet-stream")
+	respUpload, err := client.Do(reqUpload)
+	if err != nil {
+		return err
+	}
+	defer respUpload.Body.Close()
+	fmt.Println("INFO: PUT upload response: " + respUpload.Status)
+	if respUpload.StatusCode < 200 || respUpload.StatusCode >= 300 {
+		return fmt.Errorf("response from data upload %d", respUpload.StatusCode)
+	}
 	return nil
 }
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIRebase - moves ref from oldBase onto newBase, the layers of oldBase at the bottom of ref are replaced by the layers of newBase
+// the result is written as newRef (a bare tag keeps the repository of ref), refs that are not in the layout at ss.Path are copied first
+func OCIRebase(ss schema.ServiceSchema, ref, oldBase, newBase, newRef string) error {
+	l := layout.New(ss.Path)
+	var refs []schema.Descriptor
+	for _, r := range []string{ref, oldBase, newBase} {
+		d, err := layoutRef(ss, l, r)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, d)
+	}
+	d, oldDesc, newDesc := refs[0], refs[1], refs[2]
+	if name := d.Annotations[schema.AnnotationRefName]; name != "" && !strings.ContainsAny(newRef, "/:@") {
+		newRef = layout.Repository(name) + ":" + newRef
+	}
+
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	desc, err := rewriteImages(l, d, nil, func(m *schema.ImageManifest, config *schema.ImageConfig) error {
+		oldDigest, oldM, oldC, err := platformImage(l, oldDesc, config)
+		if err != nil {
+			return err
+		}
+		newDigest, newM, newC, err := platformImage(l, newDesc, config)
+		if err != nil {
+			return err
+		}
+		n := len(oldM.Layers)
+		if len(m.Layers) < n || len(config.RootFS.DiffIDs) < len(oldC.RootFS.DiffIDs) {
+			return fmt.Errorf("%s has fewer layers than the old base %s", ref, oldBase)
+		}
+		for i, layer := range oldM.Layers {
+			if m.Layers[i].Digest != layer.Digest {
+				return fmt.Errorf("layer %d of %s is %s, the old base %s has %s", i+1, ref, m.Layers[i].Digest, oldBase, layer.Digest)
+			}
+		}
+		for i, diffID := range oldC.RootFS.DiffIDs {
+			if config.RootFS.DiffIDs[i] != diffID {
+				return fmt.Errorf("diff_id %d of %s does not match the old base %s", i+1, ref, oldBase)
+			}
+		}
+		if len(config.History) < len(oldC.History) {
+			return fmt.Errorf("%s has a shorter history than the old base %s", ref, oldBase)
+		}
+		for i, h := range oldC.History {
+			if config.History[i] != h {
+				return fmt.Errorf("history entry %d of %s does not match the old base %s", i+1, ref, oldBase)
+			}
+		}
+		for _, layer := range newM.Layers {
+			if !l.HasBlob(layer) {
+				return fmt.Errorf("layer %s of the new base %s is not in the layout", layer.Digest, newBase)
+			}
+		}
+
+		fmt.Printf("INFO: replacing %d base layers of %s/%s with %d layers of %s\n", n, config.OS, config.Architecture, len(newM.Layers), newDigest)
+		m.Layers = append(append([]schema.Descriptor{}, newM.Layers...), m.Layers[n:]...)
+		config.RootFS.DiffIDs = append(append([]string{}, newC.RootFS.DiffIDs...), config.RootFS.DiffIDs[len(oldC.RootFS.DiffIDs):]...)
+		// the history of the old base (empty layers included) is the start of the history of ref
+		own := config.History[len(oldC.History):]
+		config.History = append(append(append([]schema.HistorySchema{}, newC.History...), own...), schema.HistorySchema{
+			Created:    time.Now().UTC().Format(time.RFC3339),
+			CreatedBy:  "oci rebase",
+			Comment:    fmt.Sprintf("rebased from %s to %s", oldDigest, newDigest),
+			EmptyLayer: true,
+		})
+
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[schema.AnnotationBaseImageDigest] = newDigest
+		if name := newDesc.Annotations[schema.AnnotationRefName]; name != "" {
+			m.Annotations[schema.AnnotationBaseImageName] = name
+		} else {
+			delete(m.Annotations, schema.AnnotationBaseImageName)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	return l.AddRef(desc, newRef)
+}
+
+// layoutRef - finds ref in the layout, a full image reference that is not there is copied from its registry
+func layoutRef(ss schema.ServiceSchema, l *layout.Layout, ref string) (schema.Descriptor, error) {
+	d, err := l.Resolve(ref)
+	if err == nil || !strings.Contains(ref, "/") {
+		return d, err
+	}
+	image, version := SplitReference(ref)
+	rs, err := NewServiceSchema(image, version, ss.Path, ss.TLS, ss.Auth)
+	if err != nil {
+		return d, err
+	}
+	fmt.Println("INFO: copying ", ref, " to the layout")
+	if err := OCICopyToDisk(rs); err != nil {
+		return d, err
+	}
+	return l.Resolve(refName(rs))
+}
+
+// platformImage - the image of d (or of the index d) for the platform of config, with its digest
+func platformImage(l *layout.Layout, d schema.Descriptor, config *schema.ImageConfig) (string, schema.ImageManifest, schema.ImageConfig, error) {
+	if d.MediaType == schema.MediaTypeImageIndex || d.MediaType == schema.MediaTypeDockerManifestList {
+		data, err := l.ReadBlob(d.Digest)
+		if err != nil {
+			return "", schema.ImageManifest{}, schema.ImageConfig{}, err
+		}
+		index, err := schema.ParseImageIndex(data)
+		if err != nil {
+			return "", schema.ImageManifest{}, schema.ImageConfig{}, err
+		}
+		var found bool
+		for _, child := range index.Manifests {
+			p := child.Platform
+			if p != nil && p.OS == config.OS && p.Architecture == config.Architecture && (p.Variant == "" || config.Variant == "" || p.Variant == config.Variant) {
+				d, found = child, true
+				break
+			}
+		}
+		if !found {
+			return "", schema.ImageManifest{}, schema.ImageConfig{}, fmt.Errorf("index %s has no image for %s/%s", d.Digest, config.OS, config.Architecture)
+		}
+	}
+	m, c, err := readImage(l, d.Digest)
+	if err != nil {
+		return d.Digest, m, c, err
+	}
+	if c.OS != config.OS || c.Architecture != config.Architecture {
+		return d.Digest, m, c, fmt.Errorf("base %s is %s/%s, the image is %s/%s", d.Digest, c.OS, c.Architecture, config.OS, config.Architecture)
+	}
+	return d.Digest, m, c, nil
+}
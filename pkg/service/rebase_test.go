@@ -0,0 +1,115 @@
+package service
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// layeredImage - writes an image of the layers (each holding one file named after its content) with history to the layout as ref
+func layeredImage(t *testing.T, path, ref string, contents []string, history []schema.HistorySchema) schema.Descriptor {
+	t.Helper()
+	var m schema.ImageManifest
+	config := schema.ImageConfig{Architecture: "amd64", OS: "linux", RootFS: schema.RootFS{Type: "layers", DiffIDs: []string{}}, History: history}
+	for _, content := range contents {
+		layer := testLayer(t, path, file(content, content))
+		r, err := openUncompressed(path, layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Layers = append(m.Layers, layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, schema.Digest(data))
+	}
+	d, err := writeImage(path, m, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.New(path).AddRef(d, ref); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestOCIRebase(t *testing.T) {
+	base := []schema.HistorySchema{{Created: "2024-01-01T00:00:00Z", CreatedBy: "ADD rootfs"}, {Created: "2024-01-01T00:00:00Z", CreatedBy: "ENV A=1", EmptyLayer: true}}
+	app := schema.HistorySchema{Created: "2024-02-01T00:00:00Z", CreatedBy: "COPY app"}
+	fixed := []schema.HistorySchema{{Created: "2024-03-01T00:00:00Z", CreatedBy: "ADD rootfs"}, {Created: "2024-03-01T00:00:00Z", CreatedBy: "RUN fix"}}
+	tests := []struct {
+		name    string
+		layers  []string
+		history []schema.HistorySchema
+		err     string
+	}{
+		{"on the old base", []string{"base", "app"}, append(append([]schema.HistorySchema{}, base...), app), ""},
+		{"other layers", []string{"other", "app"}, append(append([]schema.HistorySchema{}, base...), app), "layer 1 of"},
+		{"other history", []string{"base", "app"}, []schema.HistorySchema{base[0], app}, "history entry 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			layeredImage(t, path, "quay.io/ourorg/base:v1.0", []string{"base"}, base)
+			newBase := layeredImage(t, path, "quay.io/ourorg/base:v1.1", []string{"base-fixed", "fix"}, fixed)
+			layeredImage(t, path, "quay.io/ourorg/app:v1", tt.layers, tt.history)
+
+			err := OCIRebase(schema.ServiceSchema{Path: path}, "app:v1", "base:v1.0", "base:v1.1", "v1-rebased")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("OCIRebase error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := layout.New(path)
+			d, err := l.Resolve("quay.io/ourorg/app:v1-rebased")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, config, err := readImage(l, d.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newM, newC, err := readImage(l, newBase.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, appC, err := readImage(l, mustResolve(t, l, "app:v1").Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Layers) != 3 || !reflect.DeepEqual(m.Layers[:2], newM.Layers) {
+				t.Fatalf("rebased layers %v, want the new base layers and the app layer", m.Layers)
+			}
+			if want := append(append([]string{}, newC.RootFS.DiffIDs...), appC.RootFS.DiffIDs[1]); !reflect.DeepEqual(config.RootFS.DiffIDs, want) {
+				t.Fatalf("rebased diff_ids %v, want %v", config.RootFS.DiffIDs, want)
+			}
+			if len(config.History) != 4 || !reflect.DeepEqual(config.History[:3], append(append([]schema.HistorySchema{}, fixed...), app)) ||
+				config.History[3].CreatedBy != "oci rebase" || !config.History[3].EmptyLayer {
+				t.Fatalf("rebased history %+v", config.History)
+			}
+			if m.Annotations[schema.AnnotationBaseImageDigest] != newBase.Digest || m.Annotations[schema.AnnotationBaseImageName] != "quay.io/ourorg/base:v1.1" {
+				t.Fatalf("rebased annotations %v", m.Annotations)
+			}
+		})
+	}
+}
+
+// mustResolve - resolves ref in the layout or fails the test
+func mustResolve(t *testing.T, l *layout.Layout, ref string) schema.Descriptor {
+	t.Helper()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// the next page of the referrers api
+var linkRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+
+// fetchReferrers - the manifests whose subject is digest, from the referrers api
+// registries without it (any error status, not only 404) are asked for the referrers tag (sha256-<hex>), there are none if that is missing too
+func fetchReferrers(client *http.Client, ss schema.ServiceSchema, digest string) ([]schema.Descriptor, error) {
+	var referrers []schema.Descriptor
+	url := ss.URL + "/referrers/" + digest
+	for url != "" {
+		req, err := newRequest(ss, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", schema.MediaTypeImageIndex)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// docker hub and older harbor answer 400, 401 or 405 instead of 404
+		if resp.StatusCode != http.StatusOK && len(referrers) == 0 {
+			resp.Body.Close()
+			return fetchReferrersTag(client, ss, digest)
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var index schema.ImageIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, index.Manifests...)
+
+		url = ""
+		if m := linkRegex.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			url = m[1]
+			if strings.HasPrefix(url, "/") {
+				url = ss.URL[:strings.Index(ss.URL, apiVersion)] + url
+			}
+		}
+	}
+	return referrers, nil
+}
+
+// fetchReferrersTag - the referrers of digest from the index tagged sha256-<hex>, kept by registries without the referrers api
+func fetchReferrersTag(client *http.Client, ss schema.ServiceSchema, digest string) ([]schema.Descriptor, error) {
+	data, _, err := fetchManifest(client, ss, referrersTag(digest))
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index schema.ImageIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+// referrersTag - the tag a digest's referrers (or with a suffix, cosign artifacts) are stored under
+func referrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// isNotFound - true for a registry error with status 404
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIRepack - adds what changed in dir/rootfs (unpacked by OCIUnpack) as a new layer on top of the unpacked image
+// the new manifest and config are written to the layout at ss.Path as ref, ready to be pushed
+func OCIRepack(ss schema.ServiceSchema, dir, ref string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, rootfs.StateFile))
+	if err != nil {
+		return fmt.Errorf("%s was not unpacked by unpack: %v", dir, err)
+	}
+	var state schema.UnpackState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	opts, err := idMapOptions(ss)
+	if err != nil {
+		return err
+	}
+
+	l := layout.New(ss.Path)
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	m, config, err := readImage(l, state.Manifest)
+	if err != nil {
+		return err
+	}
+
+	var changes int
+	layer, diffID, err := writeLayer(ss.Path, ss.Compression, func(w io.Writer) error {
+		changes, err = rootfs.Diff(filepath.Join(dir, rootfs.RootfsDir), &state, opts, w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if changes == 0 {
+		return fmt.Errorf("nothing changed in %s", filepath.Join(dir, rootfs.RootfsDir))
+	}
+	fmt.Println("INFO: new layer ", layer.Digest, " with ", changes, " changes")
+
+	created := time.Now().UTC().Format(time.RFC3339)
+	config.Created = created
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, schema.HistorySchema{
+		Created:   created,
+		CreatedBy: "oci repack " + filepath.Base(dir),
+		Comment:   fmt.Sprintf("%d changes to %s", changes, state.Ref),
+	})
+	m.Layers = append(m.Layers, layer)
+	desc, err := writeImage(ss.Path, m, config)
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: writing index.json ", desc.Digest)
+	return l.AddRef(desc, ref)
+}
+
+// readImage - reads an image manifest and its config from the layout
+// docker v2 manifests (kept as they were fetched by copy with artifacts or signatures) are returned as their oci equivalent
+func readImage(l *layout.Layout, digest string) (schema.ImageManifest, schema.ImageConfig, error) {
+	var config schema.ImageConfig
+	data, err := l.ReadBlob(digest)
+	if err != nil {
+		return schema.ImageManifest{}, config, err
+	}
+	var m schema.ImageManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, config, fmt.Errorf("manifest: %v", err)
+	}
+	if m.MediaType == schema.MediaTypeDockerManifest {
+		m.MediaType = schema.MediaTypeImageManifest
+		m.Config.MediaType = ociMediaType(m.Config.MediaType)
+		for i := range m.Layers {
+			m.Layers[i].MediaType = ociMediaType(m.Layers[i].MediaType)
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return m, config, err
+	}
+	if m.Config.MediaType != schema.MediaTypeImageConfig && m.Config.MediaType != schema.MediaTypeDockerConfig {
+		return m, config, fmt.Errorf("%s is not an image (config %s)", digest, m.Config.MediaType)
+	}
+	data, err = l.ReadBlob(m.Config.Digest)
+	if err != nil {
+		return m, config, err
+	}
+	return m, config, json.Unmarshal(data, &config)
+}
+
+// writeImage - writes the config and then the manifest pointing to it (as an oci manifest) to the layout
+func writeImage(path string, m schema.ImageManifest, config schema.ImageConfig) (schema.Descriptor, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	if m.Config, err = writeBlob(path, schema.MediaTypeImageConfig, data); err != nil {
+		return m.Config, err
+	}
+	m.SchemaVersion = 2
+	m.MediaType = schema.MediaTypeImageManifest
+	for i := range m.Layers {
+		m.Layers[i].MediaType = ociMediaType(m.Layers[i].MediaType)
+	}
+	if err := m.Validate(); err != nil {
+		return schema.Descriptor{}, err
+	}
+	data, err = json.Marshal(m)
+	if err != nil {
+		return schema.Descriptor{}, err
+	}
+	return writeBlob(path, schema.MediaTypeImageManifest, data)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version - a (loosely parsed) semantic version, missing minor/patch default to 0 and a leading v is allowed
+type Version struct {
+	Major int64
+	Minor int64
+	Patch int64
+	Pre   string
+}
+
+// ParseVersion - parses versions like 4.11, v1.2.3 or 1.2.3-rc.1+build
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(str, "+"); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.Index(str, "-"); i >= 0 {
+		v.Pre = str[i+1:]
+		str = str[:i]
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) == 0 || len(parts) > 3 || parts[0] == "" {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// Compare - returns -1, 0 or 1 (pre-releases sort before the release)
+func (v Version) Compare(o Version) int {
+	for _, c := range [][2]int64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] < c[1] {
+			return -1
+		}
+		if c[0] > c[1] {
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	}
+	return comparePrerelease(v.Pre, o.Pre)
+}
+
+// isReleaseOf - true if v is a release and o a pre-release of it
+func (v Version) isReleaseOf(o Version) bool {
+	return v.Pre == "" && o.Pre != "" && v.Major == o.Major && v.Minor == o.Minor && v.Patch == o.Patch
+}
+
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseInt(as[i], 10, 64)
+		bn, berr := strconv.ParseInt(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil && berr != nil:
+			return -1
+		case aerr != nil && berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// VersionRange - comparators such as ">=4.10 <4.13", alternatives are separated with ||
+type VersionRange [][]comparator
+
+type comparator struct {
+	op      string
+	version Version
+}
+
+// ParseVersionRange - parses a range expression, an empty expression matches every version
+func ParseVersionRange(expr string) (VersionRange, error) {
+	var vr VersionRange
+	for _, alt := range strings.Split(expr, "||") {
+		var set []comparator
+		for _, field := range strings.Fields(alt) {
+			rest := strings.TrimLeft(field, "<>=!")
+			op := field[:len(field)-len(rest)]
+			switch op {
+			case "", "==":
+				op = "="
+			case ">", ">=", "<", "<=", "=", "!=":
+			default:
+				return nil, fmt.Errorf("invalid operator %q in version range %q", op, expr)
+			}
+			v, err := ParseVersion(rest)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, comparator{op: op, version: v})
+		}
+		if len(set) > 0 {
+			vr = append(vr, set)
+		}
+	}
+	return vr, nil
+}
+
+// Contains - checks if a version satisfies the range
+func (vr VersionRange) Contains(v Version) bool {
+	if len(vr) == 0 {
+		return true
+	}
+	for _, set := range vr {
+		ok := true
+		for _, c := range set {
+			cmp := v.Compare(c.version)
+			switch c.op {
+			case ">":
+				ok = cmp > 0
+			case ">=":
+				ok = cmp >= 0
+			case "<":
+				// 4.13.0-rc.1 sorts before 4.13.0 but is not below a <4.13 bound
+				ok = cmp < 0 && !c.version.isReleaseOf(v)
+			case "<=":
+				ok = cmp <= 0
+			case "!=":
+				ok = cmp != 0
+			default:
+				ok = cmp == 0
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
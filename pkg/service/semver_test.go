@@ -0,0 +1,102 @@
+package service
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+		err  bool
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"v4.11", Version{Major: 4, Minor: 11}, false},
+		{"4", Version{Major: 4}, false},
+		{"1.2.3-rc.1+build.5", Version{Major: 1, Minor: 2, Patch: 3, Pre: "rc.1"}, false},
+		{"latest", Version{}, true},
+		{"1.2.3.4", Version{}, true},
+		{"1.-2", Version{}, true},
+		{"", Version{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseVersion(%q) error %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+	}
+	for _, tt := range tests {
+		a, _ := ParseVersion(tt.a)
+		b, _ := ParseVersion(tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s compared to %s = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("%s compared to %s = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestVersionRangeContains(t *testing.T) {
+	tests := []struct {
+		expr    string
+		version string
+		want    bool
+	}{
+		{"", "0.0.1", true},
+		{">=1.2.0 <2.0.0", "1.2.0", true},
+		{">=1.2.0 <2.0.0", "1.9.9", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">=1.2.0 <2.0.0", "1.1.9", false},
+		{">=1.2.0 <2.0.0", "2.0.0-rc.1", false},
+		{"<=2.0.0", "2.0.0-rc.1", true},
+		{">1.0", "1.0.1", true},
+		{">1.0", "1.0.0", false},
+		{"1.4.0", "1.4.0", true},
+		{"==1.4.0", "1.4.1", false},
+		{"!=1.4.0", "1.4.1", true},
+		{"<1.0 || >=2.0", "0.9.0", true},
+		{"<1.0 || >=2.0", "1.5.0", false},
+		{"<1.0 || >=2.0", "2.1.0", true},
+	}
+	for _, tt := range tests {
+		vr, err := ParseVersionRange(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseVersionRange(%q): %v", tt.expr, err)
+		}
+		v, err := ParseVersion(tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vr.Contains(v); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.expr, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionRangeErrors(t *testing.T) {
+	for _, expr := range []string{"~1.2", ">>1.2", "=>1.2", ">=abc", "<1.2.3.4"} {
+		if _, err := ParseVersionRange(expr); err == nil {
+			t.Errorf("ParseVersionRange(%q) accepted an invalid range", expr)
+		}
+	}
+}
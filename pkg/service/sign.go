@@ -0,0 +1,194 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// OCISign - signs the manifest (or index) of ref in the layout at ss.Path with the private key ss.SigningKey, as cosign does
+// the signature is stored as the cosign signature image of the digest (ref sha256-<hex>.sig in the repository of ref), push publishes it with the image
+// signatures made before are kept, ss.Edits.Annotations are added to the optional part of the payload
+// the payload names the repository of ref in the layout, or ss.SigningReference when the image is pushed elsewhere (verifiers may check it)
+func OCISign(ss schema.ServiceSchema, ref string) error {
+	if ss.SigningKey == "" {
+		return fmt.Errorf("a private key is needed to sign %s", ref)
+	}
+	key, err := loadPrivateKey(ss.SigningKey, os.Getenv("COSIGN_PASSWORD"))
+	if err != nil {
+		return err
+	}
+	l := layout.New(ss.Path)
+	// blobs are content addressed, the shared lock only keeps gc away until the signature is in index.json
+	release, err := l.RLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	name := d.Annotations[schema.AnnotationRefName]
+	repo := ""
+	if strings.ContainsAny(name, "/:@") {
+		repo = layout.Repository(name)
+	}
+
+	var p schema.SimpleSigning
+	p.Critical.Identity.DockerReference = repo
+	if ss.SigningReference != "" {
+		p.Critical.Identity.DockerReference = ss.SigningReference
+	}
+	p.Critical.Image.DockerManifestDigest = d.Digest
+	p.Critical.Type = schema.CosignSignatureType
+	optional, err := setKeyValues(nil, ss.Edits.Annotations)
+	if err != nil {
+		return err
+	}
+	for k, v := range optional {
+		if p.Optional == nil {
+			p.Optional = map[string]interface{}{}
+		}
+		p.Optional[k] = v
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	sig, err := signPayload(key, payload)
+	if err != nil {
+		return err
+	}
+	layer, err := writeBlob(ss.Path, schema.MediaTypeCosignSimpleSigning, payload)
+	if err != nil {
+		return err
+	}
+	layer.Annotations = map[string]string{schema.AnnotationCosignSignature: base64.StdEncoding.EncodeToString(sig)}
+
+	// every signature of a digest is a layer of its signature image
+	tag := referrersTag(d.Digest) + ".sig"
+	sigRef := tag
+	if repo != "" {
+		sigRef = repo + ":" + tag
+	}
+	// the signature image is read, changed and written back under the index lock, signing the same digest twice at once keeps both
+	err = l.UpdateRef(sigRef, func(current *schema.Descriptor) (schema.Descriptor, error) {
+		m := schema.ImageManifest{}
+		config := schema.ImageConfig{RootFS: schema.RootFS{Type: "layers"}}
+		if current != nil {
+			var err error
+			if m, config, err = readImage(l, current.Digest); err != nil {
+				return schema.Descriptor{}, err
+			}
+		}
+		m.Layers = append(m.Layers, layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.Digest)
+		return writeImage(ss.Path, m, config)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("INFO: signed ", d.Digest, " as ", sigRef)
+	return nil
+}
+
+// loadPrivateKey - reads a PEM private key (PKCS8, EC or PKCS1), cosign keys (encrypted) are decrypted with password
+func loadPrivateKey(file, password string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM private key", file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		var der []byte
+		if der, err = decryptCosignKey(block.Bytes, password); err == nil {
+			key, err = x509.ParsePKCS8PrivateKey(der)
+		}
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, fmt.Errorf("%s: encrypted PKCS8 keys are not supported, use a cosign key or decrypt it first", file)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM type %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, key)
+	}
+	return signer, nil
+}
+
+// decryptCosignKey - the PKCS8 key of a cosign key file, encrypted with nacl/secretbox and a key derived from the password with scrypt
+func decryptCosignKey(data []byte, password string) ([]byte, error) {
+	var enc struct {
+		KDF struct {
+			Name   string `json:"name"`
+			Params struct {
+				N int `json:"N"`
+				R int `json:"r"`
+				P int `json:"p"`
+			} `json:"params"`
+			Salt []byte `json:"salt"`
+		} `json:"kdf"`
+		Cipher struct {
+			Name  string `json:"name"`
+			Nonce []byte `json:"nonce"`
+		} `json:"cipher"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, err
+	}
+	if enc.KDF.Name != "scrypt" || enc.Cipher.Name != "nacl/secretbox" || len(enc.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported key encryption %s %s", enc.KDF.Name, enc.Cipher.Name)
+	}
+	derived, err := scrypt.Key([]byte(password), enc.KDF.Salt, enc.KDF.Params.N, enc.KDF.Params.R, enc.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var secret [32]byte
+	var nonce [24]byte
+	copy(secret[:], derived)
+	copy(nonce[:], enc.Cipher.Nonce)
+	der, ok := secretbox.Open(nil, enc.Ciphertext, &nonce, &secret)
+	if !ok {
+		return nil, fmt.Errorf("wrong password (COSIGN_PASSWORD)")
+	}
+	return der, nil
+}
+
+// signPayload - signs the payload (sha256, ed25519 signs it as is) the way verifySignature checks it
+func signPayload(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(payload)
+	return key.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
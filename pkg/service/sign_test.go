@@ -0,0 +1,271 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// testKeys - a signer of every supported key type
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"ecdsa": ec, "ed25519": ed, "rsa": rsaKey}
+}
+
+func TestSignPayloadRoundTrip(t *testing.T) {
+	payload := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	keys := testKeys(t)
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			sig, err := signPayload(key, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifySignature(key.Public(), payload, sig); err != nil {
+				t.Fatalf("verifySignature: %v", err)
+			}
+			if err := verifySignature(key.Public(), append(payload, ' '), sig); err == nil {
+				t.Fatal("verifySignature accepted a changed payload")
+			}
+			for other, o := range keys {
+				if other != name && verifySignature(o.Public(), payload, sig) == nil {
+					t.Fatalf("verifySignature accepted the %s key", other)
+				}
+			}
+		})
+	}
+}
+
+// writeKey - writes a PEM block to a file of dir
+func writeKey(t *testing.T, dir, name, pemType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// cosignKey - the PKCS8 key encrypted the way cosign generate-key-pair does
+func cosignKey(t *testing.T, der []byte, password string) []byte {
+	t.Helper()
+	salt := make([]byte, 32)
+	nonce := [24]byte{}
+	rand.Read(salt)
+	rand.Read(nonce[:])
+	// small scrypt parameters keep the test fast, cosign uses N=32768
+	derived, err := scrypt.Key([]byte(password), salt, 1024, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret [32]byte
+	copy(secret[:], derived)
+	enc := map[string]interface{}{
+		"kdf":        map[string]interface{}{"name": "scrypt", "params": map[string]int{"N": 1024, "r": 8, "p": 1}, "salt": salt},
+		"cipher":     map[string]interface{}{"name": "nacl/secretbox", "nonce": nonce[:]},
+		"ciphertext": secretbox.Seal(nil, der, &nonce, &secret),
+	}
+	data, err := json.Marshal(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	keys := testKeys(t)
+	ec := keys["ecdsa"].(*ecdsa.PrivateKey)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(keys["ed25519"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		file     string
+		password string
+		public   crypto.PublicKey
+		err      bool
+	}{
+		{"pkcs8", writeKey(t, dir, "pkcs8.key", "PRIVATE KEY", pkcs8), "", ec.Public(), false},
+		{"ec", writeKey(t, dir, "ec.key", "EC PRIVATE KEY", ecDER), "", ec.Public(), false},
+		{"ed25519", writeKey(t, dir, "ed.key", "PRIVATE KEY", edDER), "", keys["ed25519"].Public(), false},
+		{"rsa", writeKey(t, dir, "rsa.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(keys["rsa"].(*rsa.PrivateKey))), "", keys["rsa"].Public(), false},
+		{"cosign", writeKey(t, dir, "cosign.key", "ENCRYPTED SIGSTORE PRIVATE KEY", cosignKey(t, pkcs8, "s3cret")), "s3cret", ec.Public(), false},
+		{"cosign wrong password", writeKey(t, dir, "cosign2.key", "ENCRYPTED SIGSTORE PRIVATE KEY", cosignKey(t, pkcs8, "s3cret")), "wrong", nil, true},
+		{"encrypted pkcs8", writeKey(t, dir, "enc.key", "ENCRYPTED PRIVATE KEY", []byte("x")), "", nil, true},
+		{"public key", writeKey(t, dir, "ec.pub", "PUBLIC KEY", []byte("x")), "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := loadPrivateKey(tt.file, tt.password)
+			if (err != nil) != tt.err {
+				t.Fatalf("loadPrivateKey error %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(tt.public) {
+				t.Fatalf("loadPrivateKey returned another key")
+			}
+		})
+	}
+}
+
+func TestOCISign(t *testing.T) {
+	dir := t.TempDir()
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeKey(t, t.TempDir(), "cosign.key", "PRIVATE KEY", der)
+
+	l := layout.New(dir)
+	desc, err := writeImage(dir, schema.ImageManifest{SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Layers: []schema.Descriptor{}},
+		schema.ImageConfig{Architecture: "amd64", OS: "linux", RootFS: schema.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AddRef(desc, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := schema.ServiceSchema{Path: dir, SigningKey: keyFile}
+	if err := OCISign(ss, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	ss.SigningReference = "registry.example.com/app"
+	if err := OCISign(ss, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := l.Resolve("quay.io/ourorg/app:" + referrersTag(desc.Digest) + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := readImage(l, sig.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != 2 {
+		t.Fatalf("signature image has %d signatures, want 2", len(m.Layers))
+	}
+	for i, reference := range []string{"quay.io/ourorg/app", "registry.example.com/app"} {
+		layer := m.Layers[i]
+		payload, err := l.ReadBlob(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p schema.SimpleSigning
+		if err := json.Unmarshal(payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Critical.Image.DockerManifestDigest != desc.Digest || p.Critical.Identity.DockerReference != reference {
+			t.Fatalf("payload %d names %s@%s, want %s@%s", i, p.Critical.Identity.DockerReference, p.Critical.Image.DockerManifestDigest, reference, desc.Digest)
+		}
+		s, err := base64.StdEncoding.DecodeString(layer.Annotations[schema.AnnotationCosignSignature])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifySignature(ec.Public(), payload, s); err != nil {
+			t.Fatalf("signature %d: %v", i, err)
+		}
+	}
+}
+
+func TestOCISignConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	l := layout.New(dir)
+	desc, err := writeImage(dir, schema.ImageManifest{SchemaVersion: 2, MediaType: schema.MediaTypeImageManifest, Layers: []schema.Descriptor{}},
+		schema.ImageConfig{Architecture: "amd64", OS: "linux", RootFS: schema.RootFS{Type: "layers"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AddRef(desc, "quay.io/ourorg/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	// a copy in progress holds the shared lock, signing does not wait for it
+	release, err := l.RLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	const signers = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, signers)
+	for i := 0; i < signers; i++ {
+		ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(ec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss := schema.ServiceSchema{Path: dir, SigningKey: writeKey(t, t.TempDir(), "cosign.key", "PRIVATE KEY", der)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- OCISign(ss, "quay.io/ourorg/app:v1")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig, err := l.Resolve("quay.io/ourorg/app:" + referrersTag(desc.Digest) + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := readImage(l, sig.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != signers {
+		t.Fatalf("signature image has %d signatures, want %d", len(m.Layers), signers)
+	}
+}
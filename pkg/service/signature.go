@@ -0,0 +1,389 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// signatureVerifier - the public keys and roots signatures are checked against
+type signatureVerifier struct {
+	keys  []crypto.PublicKey
+	roots *x509.CertPool
+	// certificates must be issued to identity by issuer and logged in the transparency log of a rekor key
+	identity string
+	issuer   string
+	rekor    []crypto.PublicKey
+}
+
+// newSignatureVerifier - loads the keys and roots of the policy
+func newSignatureVerifier(p schema.SignaturePolicy) (*signatureVerifier, error) {
+	switch p.Mode {
+	case "", "ignore", "warn", "enforce":
+	default:
+		return nil, fmt.Errorf("signature policy %q must be ignore, warn or enforce", p.Mode)
+	}
+	if len(p.Keys) == 0 && p.Roots == "" {
+		return nil, fmt.Errorf("signature policy %s needs public keys or roots", p.Mode)
+	}
+	v := &signatureVerifier{}
+	for _, file := range p.Keys {
+		keys, err := loadPublicKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if p.Roots != "" {
+		if p.Identity == "" || p.Issuer == "" || p.RekorKey == "" {
+			return nil, fmt.Errorf("certificate signatures need an identity, an issuer and a rekor key to be verified")
+		}
+		v.identity, v.issuer = p.Identity, p.Issuer
+		var err error
+		if v.rekor, err = loadPublicKeys(p.RekorKey); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(p.Roots)
+		if err != nil {
+			return nil, err
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s has no PEM certificates", p.Roots)
+		}
+	}
+	return v, nil
+}
+
+// loadPublicKeys - the public keys (or keys of certificates) of a PEM file
+func loadPublicKeys(file string) ([]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no PEM public keys", file)
+	}
+	return keys, nil
+}
+
+// verifySignatures - checks the cosign signatures of digest in the registry of ss against ss.Signatures, one valid signature is enough
+// signatures are found by the cosign tag (sha256-<hex>.sig) and the referrers of digest, everything is verified offline
+// missing or invalid signatures fail with the enforce policy and are only reported with warn
+func verifySignatures(client *http.Client, ss schema.ServiceSchema, digest string) error {
+	v, err := newSignatureVerifier(ss.Signatures)
+	if err != nil {
+		return err
+	}
+	err = v.verify(client, ss, digest)
+	if err != nil && ss.Signatures.Mode == "warn" {
+		fmt.Println("WARN: ", err)
+		return nil
+	}
+	if err == nil {
+		fmt.Println("INFO: verified signature of ", digest)
+	}
+	return err
+}
+
+// verify - finds the signature manifests of digest and checks their layers until one is valid
+func (v *signatureVerifier) verify(client *http.Client, ss schema.ServiceSchema, digest string) error {
+	var signatures []schema.ImageManifest
+	add := func(data []byte) error {
+		var m schema.ImageManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		signatures = append(signatures, m)
+		return nil
+	}
+	data, _, err := fetchManifest(client, ss, referrersTag(digest)+".sig")
+	if err == nil {
+		err = add(data)
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	referrers, err := fetchReferrers(client, ss, digest)
+	if err != nil {
+		return err
+	}
+	for _, r := range referrers {
+		if r.ArtifactType != schema.ArtifactTypeCosignSignature {
+			continue
+		}
+		data, _, err := fetchManifest(client, ss, r.Digest)
+		if err != nil {
+			return err
+		}
+		if err := add(data); err != nil {
+			return err
+		}
+	}
+
+	var problems []string
+	for _, m := range signatures {
+		for _, layer := range m.Layers {
+			if layer.MediaType != schema.MediaTypeCosignSimpleSigning {
+				continue
+			}
+			err := v.verifyLayer(client, ss, layer, digest)
+			if err == nil {
+				return nil
+			}
+			problems = append(problems, fmt.Sprintf("%s: %v", layer.Digest, err))
+		}
+	}
+	if len(problems) == 0 {
+		return fmt.Errorf("%s%s@%s has no signatures", ss.User, ss.Component, digest)
+	}
+	return fmt.Errorf("%s%s@%s has no valid signature (%s)", ss.User, ss.Component, digest, strings.Join(problems, ", "))
+}
+
+// verifyLayer - checks one signature, its payload must name digest and be signed by a key or a logged certificate chaining to the roots
+func (v *signatureVerifier) verifyLayer(client *http.Client, ss schema.ServiceSchema, layer schema.Descriptor, digest string) error {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[schema.AnnotationCosignSignature])
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("no signature annotation")
+	}
+	payload, err := fetchBlob(client, ss, layer)
+	if err != nil {
+		return err
+	}
+	var p schema.SimpleSigning
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("payload: %v", err)
+	}
+	if p.Critical.Type != schema.CosignSignatureType {
+		return fmt.Errorf("payload type %q is not a cosign signature", p.Critical.Type)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("payload is for %s", p.Critical.Image.DockerManifestDigest)
+	}
+
+	if cert := layer.Annotations[schema.AnnotationCosignCertificate]; cert != "" && v.roots != nil {
+		key, err := v.verifyCertificate(cert, layer.Annotations[schema.AnnotationCosignChain], layer.Annotations[schema.AnnotationCosignBundle], payload, sig)
+		if err != nil {
+			return err
+		}
+		return verifySignature(key, payload, sig)
+	}
+	for _, key := range v.keys {
+		if verifySignature(key, payload, sig) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("not signed by any of the keys")
+}
+
+// verifyCertificate - checks the signing certificate chains to the roots and was issued to the identity by the issuer, returning its public key
+// the bundle must hold a transparency log entry for sig and payload, signed by the rekor key, and the certificate must have been valid when it was logged
+func (v *signatureVerifier) verifyCertificate(cert, chain, bundle string, payload, sig []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return nil, fmt.Errorf("certificate annotation is not PEM")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !certificateIdentity(leaf, v.identity) {
+		return nil, fmt.Errorf("certificate is not issued to %s", v.identity)
+	}
+	if issuer := certificateIssuer(leaf); issuer != v.issuer {
+		return nil, fmt.Errorf("certificate issuer %q is not %s", issuer, v.issuer)
+	}
+	if bundle == "" {
+		return nil, fmt.Errorf("no transparency log bundle")
+	}
+	at, err := v.verifyBundle(bundle, leaf, payload, sig)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(chain))
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leaf.PublicKey, nil
+}
+
+// rekorPayload - the transparency log entry of a bundle, its fields in the order of the canonical json the log signs
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// verifyBundle - checks the signed entry timestamp of the bundle with a rekor key and that the entry is for sig, payload and leaf
+// returns the time the entry was logged
+func (v *signatureVerifier) verifyBundle(bundle string, leaf *x509.Certificate, payload, sig []byte) (time.Time, error) {
+	var b struct {
+		SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+		Payload              rekorPayload `json:"Payload"`
+	}
+	if err := json.Unmarshal([]byte(bundle), &b); err != nil {
+		return time.Time{}, err
+	}
+	signed, err := json.Marshal(b.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	verified := false
+	for _, key := range v.rekor {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			continue
+		}
+		logID := sha256.Sum256(der)
+		if hex.EncodeToString(logID[:]) == b.Payload.LogID && verifySignature(key, signed, b.SignedEntryTimestamp) == nil {
+			verified = true
+		}
+	}
+	if !verified {
+		return time.Time{}, fmt.Errorf("entry is not signed by the rekor key")
+	}
+
+	// hashedrekord (or rekord) entries hold the signature, the hash of the payload and the certificate
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var entry struct {
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   string `json:"content"`
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, err
+	}
+	sum := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(sum[:]) {
+		return time.Time{}, fmt.Errorf("entry is not for this payload")
+	}
+	if logged, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.Content); err != nil || !bytes.Equal(logged, sig) {
+		return time.Time{}, fmt.Errorf("entry is not for this signature")
+	}
+	pemCert, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if block, _ := pem.Decode(pemCert); block == nil || !bytes.Equal(block.Bytes, leaf.Raw) {
+		return time.Time{}, fmt.Errorf("entry is not for this certificate")
+	}
+	return time.Unix(b.Payload.IntegratedTime, 0), nil
+}
+
+// certificateIdentity - true if identity is an email or uri subject alternative name of cert
+func certificateIdentity(cert *x509.Certificate, identity string) bool {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// fulcio records the oidc issuer of a certificate in these extensions, the first holds the raw string
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// certificateIssuer - the oidc issuer of a fulcio certificate
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidIssuerV1):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// verifySignature - checks sig is the signature of payload (sha256) by key
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	sum := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, sum[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, payload, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil || rsa.VerifyPSS(k, crypto.SHA256, sum[:], sig, nil) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return fmt.Errorf("signature does not match")
+}
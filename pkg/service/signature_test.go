@@ -0,0 +1,314 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// keyless - a CA, a leaf certificate it issued and a rekor key, as fulcio and rekor would make them
+type keyless struct {
+	roots  *x509.CertPool
+	leaf   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	rekor  *ecdsa.PrivateKey
+	logged time.Time
+}
+
+func newKeyless(t *testing.T, email, issuer string) keyless {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatal(err)
+	}
+	issuerExt, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fulcio certificates are only valid for minutes, the signature must have been logged then
+	k := keyless{roots: x509.NewCertPool(), logged: time.Now().Add(-50 * time.Minute)}
+	k.roots.AddCert(ca)
+	if k.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if k.rekor, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       k.logged.Add(-time.Minute),
+		NotAfter:        k.logged.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{email},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuerExt}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &k.key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.leaf, err = x509.ParseCertificate(leafDER); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func (k keyless) verifier(identity, issuer string) *signatureVerifier {
+	return &signatureVerifier{roots: k.roots, identity: identity, issuer: issuer, rekor: []crypto.PublicKey{&k.rekor.PublicKey}}
+}
+
+func (k keyless) certPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.leaf.Raw}))
+}
+
+// bundle - the rekor bundle of a hashedrekord entry for payload and sig, signed by the rekor key
+func (k keyless) bundle(t *testing.T, payload, sig []byte, integratedTime time.Time) string {
+	t.Helper()
+	sum := sha256.Sum256(payload)
+	entry := map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])}},
+			"signature": map[string]interface{}{
+				"content":   base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString([]byte(k.certPEM()))},
+			},
+		},
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&k.rekor.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	p := rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: integratedTime.Unix(),
+		LogID:          hex.EncodeToString(logID[:]),
+		LogIndex:       42,
+	}
+	signed, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := signPayload(k.rekor, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]interface{}{"SignedEntryTimestamp": set, "Payload": p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	const email, issuer = "dev@example.com", "https://accounts.example.com"
+	k := newKeyless(t, email, issuer)
+	payload := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	sig, err := signPayload(k.key, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSig, err := signPayload(k.key, []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newKeyless(t, email, issuer)
+
+	tampered := k.bundle(t, payload, sig, k.logged)
+	tampered = strings.Replace(tampered, `"integratedTime":`, `"integratedTime":1`, 1)
+
+	tests := []struct {
+		name     string
+		verifier *signatureVerifier
+		bundle   string
+		sig      []byte
+		err      string
+	}{
+		{"valid", k.verifier(email, issuer), k.bundle(t, payload, sig, k.logged), sig, ""},
+		{"wrong identity", k.verifier("other@example.com", issuer), k.bundle(t, payload, sig, k.logged), sig, "not issued to"},
+		{"wrong issuer", k.verifier(email, "https://other.example.com"), k.bundle(t, payload, sig, k.logged), sig, "issuer"},
+		{"no bundle", k.verifier(email, issuer), "", sig, "no transparency log bundle"},
+		{"backdated", k.verifier(email, issuer), tampered, sig, "not signed by the rekor key"},
+		{"other rekor key", other.verifier(email, issuer), k.bundle(t, payload, sig, k.logged), sig, "not signed by the rekor key"},
+		{"other signature", k.verifier(email, issuer), k.bundle(t, payload, sig, k.logged), otherSig, "not for this signature"},
+		{"expired when logged", k.verifier(email, issuer), k.bundle(t, payload, sig, time.Now()), sig, "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.verifier.verifyCertificate(k.certPEM(), "", tt.bundle, payload, tt.sig)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("verifyCertificate: %v", err)
+				}
+				if err := verifySignature(key, payload, tt.sig); err != nil {
+					t.Fatalf("verifySignature: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("verifyCertificate error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	uri, _ := url.Parse("https://github.com/org/repo/.github/workflows/release.yaml@refs/heads/main")
+	cert := &x509.Certificate{EmailAddresses: []string{"dev@example.com"}, URIs: []*url.URL{uri}}
+	tests := []struct {
+		identity string
+		want     bool
+	}{
+		{"dev@example.com", true},
+		{uri.String(), true},
+		{"other@example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := certificateIdentity(cert, tt.identity); got != tt.want {
+			t.Errorf("certificateIdentity(%q) = %v, want %v", tt.identity, got, tt.want)
+		}
+	}
+}
+
+func TestNewSignatureVerifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy schema.SignaturePolicy
+		err    string
+	}{
+		{"bad mode", schema.SignaturePolicy{Mode: "strict", Keys: []string{"cosign.pub"}}, "must be ignore, warn or enforce"},
+		{"nothing to verify with", schema.SignaturePolicy{Mode: "enforce"}, "needs public keys or roots"},
+		{"roots without identity", schema.SignaturePolicy{Roots: "roots.pem", Issuer: "https://accounts.example.com", RekorKey: "rekor.pub"}, "need an identity"},
+		{"roots without issuer", schema.SignaturePolicy{Roots: "roots.pem", Identity: "dev@example.com", RekorKey: "rekor.pub"}, "need an identity"},
+		{"roots without rekor key", schema.SignaturePolicy{Roots: "roots.pem", Identity: "dev@example.com", Issuer: "https://accounts.example.com"}, "need an identity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSignatureVerifier(tt.policy)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("newSignatureVerifier error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// publicKeyFile - writes the PEM public key of a policy
+func publicKeyFile(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// signedImage - uploads a cosign signature image (tag sha256-<hex>.sig) of digest made with key
+func signedImage(t *testing.T, host, repo, digest string, key crypto.Signer) {
+	t.Helper()
+	var p schema.SimpleSigning
+	p.Critical.Identity.DockerReference = host + "/" + repo
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = schema.CosignSignatureType
+	payload, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signPayload(key, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := uploadBlob(t, host, repo, schema.MediaTypeCosignSimpleSigning, payload)
+	layer.Annotations = map[string]string{schema.AnnotationCosignSignature: base64.StdEncoding.EncodeToString(sig)}
+	m := schema.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     schema.MediaTypeImageManifest,
+		Config:        uploadBlob(t, host, repo, schema.MediaTypeImageConfig, []byte(`{"architecture":"","os":"","rootfs":{"type":"layers","diff_ids":[]}}`)),
+		Layers:        []schema.Descriptor{layer},
+	}
+	uploadManifest(t, host, repo, referrersTag(digest)+".sig", schema.MediaTypeImageManifest, m)
+}
+
+func TestVerifySignaturesWithoutReferrersAPI(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := schema.SignaturePolicy{Keys: []string{publicKeyFile(t, key.Public())}}
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			host := newRegistry(t, status)
+			signed := dockerImage(t, host, "x/signed", "v1")
+			signedImage(t, host, "x/signed", signed.Digest, key)
+			unsigned := dockerImage(t, host, "x/unsigned", "v1")
+
+			ss, err := NewServiceSchema(host+"/x/signed", "v1", t.TempDir(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ss.Signatures = policy
+			client, err := newClient(ss, transport.PullScope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifySignatures(client, ss, signed.Digest); err != nil {
+				t.Fatalf("verifySignatures: %v", err)
+			}
+			us, err := NewServiceSchema(host+"/x/unsigned", "v1", t.TempDir(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			us.Signatures = policy
+			if err := verifySignatures(client, us, unsigned.Digest); err == nil || !strings.Contains(err.Error(), "has no signatures") {
+				t.Fatalf("verifySignatures error %v, want no signatures", err)
+			}
+		})
+	}
+}
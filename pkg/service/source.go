@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// contentSource - where manifests and blobs are read from, a registry or a local layout
+type contentSource interface {
+	// Manifest - raw manifest (or index) and its media type, by tag or digest
+	Manifest(reference string) ([]byte, string, error)
+	// Blob - a small blob (such as a config) read fully and verified
+	Blob(d schema.Descriptor) ([]byte, error)
+	// Open - streams a (large) blob such as a layer, as stored
+	Open(d schema.Descriptor) (io.ReadCloser, error)
+}
+
+// registrySource - reads through the authenticated client built for the service schema
+type registrySource struct {
+	client *http.Client
+	ss     schema.ServiceSchema
+}
+
+func (r *registrySource) Manifest(reference string) ([]byte, string, error) {
+	return fetchManifest(r.client, r.ss, reference)
+}
+
+func (r *registrySource) Blob(d schema.Descriptor) ([]byte, error) {
+	return fetchBlob(r.client, r.ss, d)
+}
+
+func (r *registrySource) Open(d schema.Descriptor) (io.ReadCloser, error) {
+	req, err := newRequest(r.ss, http.MethodGet, r.ss.URL+blobs+d.Digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &verifyingReader{ReadCloser: resp.Body, hash: sha256.New(), digest: d.Digest}, nil
+}
+
+// verifyingReader - fails the read that reaches the end of a blob whose content does not match its digest
+// a blob closed before its end is not verified, its connection is dropped rather than downloading what is left
+type verifyingReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := SHA256 + hex.EncodeToString(v.hash.Sum(nil)); got != v.digest {
+			return n, fmt.Errorf("blob digest mismatch: expected %s got %s", v.digest, got)
+		}
+	}
+	return n, err
+}
+
+// layoutSource - reads from an oci layout, references are digests or refs in index.json
+type layoutSource struct {
+	layout *layout.Layout
+}
+
+func (l *layoutSource) Manifest(reference string) ([]byte, string, error) {
+	d, err := l.layout.Resolve(reference)
+	if err != nil {
+		// children of an index are not refs
+		d = schema.Descriptor{Digest: reference}
+	}
+	data, err := l.layout.ReadBlob(d.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := d.MediaType
+	if mediaType == "" {
+		mediaType = schema.DetectMediaType(data)
+	}
+	return data, mediaType, nil
+}
+
+func (l *layoutSource) Blob(d schema.Descriptor) ([]byte, error) {
+	if d.Data != nil {
+		return d.Data, schema.VerifyContent(d, d.Data)
+	}
+	data, err := l.layout.ReadBlob(d.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return data, schema.VerifyContent(d, data)
+}
+
+func (l *layoutSource) Open(d schema.Descriptor) (io.ReadCloser, error) {
+	return os.Open(l.layout.BlobPath(d.Digest))
+}
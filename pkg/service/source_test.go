@@ -0,0 +1,62 @@
+package service
+
+import (
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	blob := strings.Repeat("layer content ", 1000)
+	tests := []struct {
+		name    string
+		content string
+		read    int64
+		err     bool
+	}{
+		{"read fully", blob, -1, false},
+		{"closed early", blob, 10, false},
+		{"not read", blob, 0, false},
+		{"corrupt read fully", blob + "x", -1, true},
+		// nothing is verified (or downloaded) after an early close
+		{"corrupt closed early", blob + "x", 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &closeRecorder{Reader: strings.NewReader(tt.content)}
+			v := &verifyingReader{ReadCloser: body, hash: sha256.New(), digest: schema.Digest([]byte(blob))}
+			var err error
+			if tt.read < 0 {
+				_, err = io.Copy(io.Discard, v)
+			} else {
+				_, err = io.CopyN(io.Discard, v, tt.read)
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("read error %v, want error %v", err, tt.err)
+			}
+			if err := v.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !body.closed {
+				t.Fatal("body was not closed")
+			}
+			if left := body.Len(); tt.read >= 0 && left != len(tt.content)-int(tt.read) {
+				t.Fatalf("%d bytes left unread, want %d", left, len(tt.content)-int(tt.read))
+			}
+		})
+	}
+}
+
+// closeRecorder - a body that records it was closed
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// page size requested from the registry, registries may return less
+const tagsPageSize int = 1000
+
+// OCIListTags - lists the tags of a repository, applying the tag filters in the service schema
+func OCIListTags(ss schema.ServiceSchema) ([]string, error) {
+	client, err := newClient(ss, transport.PullScope)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := listTags(client, ss)
+	if err != nil {
+		return nil, err
+	}
+	return FilterTags(tags, ss.Tags)
+}
+
+// listTags - lists all the tags of the repository in the service schema, following Link headers for pagination
+func listTags(client *http.Client, ss schema.ServiceSchema) ([]string, error) {
+	var tags []string
+	next := fmt.Sprintf("%s%s?n=%d", ss.URL, tagsList, tagsPageSize)
+	for next != "" {
+		req, err := newRequest(ss, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var tl schema.TagList
+		err = json.NewDecoder(resp.Body).Decode(&tl)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tl.Tags...)
+		next, err = nextLink(next, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextLink - resolves the rel="next" url of a Link header (empty if there is no next page)
+func nextLink(current, header string) (string, error) {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		isNext := false
+		for _, p := range parts[1:] {
+			if strings.ReplaceAll(strings.TrimSpace(p), " ", "") == `rel="next"` {
+				isNext = true
+			}
+		}
+		if !isNext {
+			continue
+		}
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		base, err := url.Parse(current)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	return "", nil
+}
+
+// FilterTags - applies the regex, semver range and exclude filters then keeps the latest N by semver
+func FilterTags(tags []string, f schema.TagFilter) ([]string, error) {
+	var include *regexp.Regexp
+	var err error
+	if f.Regex != "" {
+		include, err = regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var excludes []*regexp.Regexp
+	for _, e := range f.Exclude {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, re)
+	}
+	vr, err := ParseVersionRange(f.Range)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	versions := map[string]Version{}
+tags:
+	for _, tag := range tags {
+		if include != nil && !include.MatchString(tag) {
+			continue
+		}
+		for _, re := range excludes {
+			if re.MatchString(tag) {
+				continue tags
+			}
+		}
+		if f.Range != "" || f.Latest > 0 {
+			// tags that are not versions can't satisfy a range or be ordered
+			v, err := ParseVersion(tag)
+			if err != nil || !vr.Contains(v) {
+				continue
+			}
+			versions[tag] = v
+		}
+		matched = append(matched, tag)
+	}
+
+	if f.Latest > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			return versions[matched[i]].Compare(versions[matched[j]]) > 0
+		})
+		if len(matched) > f.Latest {
+			matched = matched[:f.Latest]
+		}
+	}
+	return matched, nil
+}
+
+// copyMatchingTags - copies every tag of the repository that passes the tag filters
+func copyMatchingTags(ss schema.ServiceSchema) error {
+	if ss.Version != "" {
+		return fmt.Errorf("a version (-v) can't be combined with tag filters")
+	}
+	tags, err := OCIListTags(ss)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("no tags of %s match the filters", ss.Image)
+	}
+	fmt.Printf("INFO: copying %d matching tags\n", len(tags))
+	var failed int
+	for _, tag := range tags {
+		ts := ss
+		ts.Version = tag
+		ts.Tags = schema.TagFilter{}
+		fmt.Println("INFO: copying tag ", tag)
+		if err := OCICopyToDisk(ts); err != nil {
+			fmt.Println(fmt.Sprintf("ERROR: %s %v", tag, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to copy %d of %d tags", failed, len(tags))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+func TestFilterTags(t *testing.T) {
+	tags := []string{"latest", "v4.10.0", "4.11", "4.12.3", "4.13.0-rc.1", "4.13.0", "4.14.0-beta", "4.14.1", "sha256-abc.sig"}
+	tests := []struct {
+		name   string
+		filter schema.TagFilter
+		want   []string
+		err    bool
+	}{
+		{"no filters", schema.TagFilter{}, tags, false},
+		{"regex", schema.TagFilter{Regex: `^4\.1[34]`}, []string{"4.13.0-rc.1", "4.13.0", "4.14.0-beta", "4.14.1"}, false},
+		{"range skips non versions", schema.TagFilter{Range: ">=4.10"}, []string{"v4.10.0", "4.11", "4.12.3", "4.13.0-rc.1", "4.13.0", "4.14.0-beta", "4.14.1"}, false},
+		{"upper bound excludes its pre-releases", schema.TagFilter{Range: ">=4.10 <4.13"}, []string{"v4.10.0", "4.11", "4.12.3"}, false},
+		{"exclude", schema.TagFilter{Range: ">=4.13", Exclude: []string{"-rc", "-beta"}}, []string{"4.13.0", "4.14.1"}, false},
+		{"latest", schema.TagFilter{Latest: 2}, []string{"4.14.1", "4.14.0-beta"}, false},
+		{"latest in range", schema.TagFilter{Range: "<4.14", Latest: 2}, []string{"4.13.0", "4.13.0-rc.1"}, false},
+		{"bad regex", schema.TagFilter{Regex: "("}, nil, true},
+		{"bad range", schema.TagFilter{Range: "~>4.10"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterTags(tags, tt.filter)
+			if (err != nil) != tt.err {
+				t.Fatalf("FilterTags error %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FilterTags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	const current = "https://quay.io/v2/ourorg/app/tags/list?n=1000"
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"no header", "", ""},
+		{"relative", `</v2/ourorg/app/tags/list?n=1000&last=v2>; rel="next"`, "https://quay.io/v2/ourorg/app/tags/list?n=1000&last=v2"},
+		{"absolute", `<https://cdn.example.com/v2/ourorg/app/tags/list?last=v2>; rel="next"`, "https://cdn.example.com/v2/ourorg/app/tags/list?last=v2"},
+		{"spaces in rel", `</v2/ourorg/app/tags/list?last=v2>; rel = "next"`, "https://quay.io/v2/ourorg/app/tags/list?last=v2"},
+		{"other rel first", `</v2/ourorg/app/tags/list?last=v0>; rel="prev", </v2/ourorg/app/tags/list?last=v2>; rel="next"`, "https://quay.io/v2/ourorg/app/tags/list?last=v2"},
+		{"no next", `</v2/ourorg/app/tags/list?last=v0>; rel="prev"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextLink(current, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("nextLink = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyMatchingTagsRejectsVersion(t *testing.T) {
+	ss := schema.ServiceSchema{Image: "quay.io/ourorg/app", Version: "v1", Tags: schema.TagFilter{Latest: 1}}
+	if err := copyMatchingTags(ss); err == nil {
+		t.Fatal("copyMatchingTags accepted a version with tag filters")
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/luigizuccarelli/golang-container-tools/pkg/layout"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/rootfs"
+	"github.com/luigizuccarelli/golang-container-tools/pkg/schema"
+)
+
+// OCIUnpack - applies the layers of a ref in the layout at ss.Path to dir/rootfs
+// dir/unpack.json records the image metadata of every path so repack can find what was changed
+func OCIUnpack(ss schema.ServiceSchema, ref, dir string) error {
+	l := layout.New(ss.Path)
+	d, err := l.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	desc, m, err := l.ResolveImage(d)
+	if err != nil {
+		return err
+	}
+	opts, err := idMapOptions(ss)
+	if err != nil {
+		return err
+	}
+
+	root := filepath.Join(dir, rootfs.RootfsDir)
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	state := &schema.UnpackState{Ref: d.Annotations[schema.AnnotationRefName], Manifest: desc.Digest, Config: m.Config.Digest}
+	u := rootfs.NewUnpacker(root, opts, state)
+	for i, layer := range m.Layers {
+		if !l.HasBlob(layer) {
+			return fmt.Errorf("layer %s is not in the layout", layer.Digest)
+		}
+		fmt.Printf("INFO: applying layer [%d/%d] %s\n", i+1, len(m.Layers), layer.Digest)
+		r, err := openUncompressed(ss.Path, layer.Digest)
+		if err != nil {
+			return err
+		}
+		err = u.Apply(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := u.Finish(); err != nil {
+		return err
+	}
+	for _, w := range u.Warnings {
+		fmt.Println("WARN: ", w)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, rootfs.StateFile), data, 0644); err != nil {
+		return err
+	}
+	fmt.Println("INFO: unpacked ", len(state.Entries), " paths to ", root)
+	return nil
+}
+
+// idMapOptions - ownership options for unpack and repack, unprivileged users get rootless behaviour
+func idMapOptions(ss schema.ServiceSchema) (rootfs.Options, error) {
+	var opts = rootfs.Options{Rootless: os.Geteuid() != 0}
+	var err error
+	if opts.UIDMap, err = rootfs.ParseIDMaps(ss.UIDMap); err != nil {
+		return opts, err
+	}
+	opts.GIDMap, err = rootfs.ParseIDMaps(ss.GIDMap)
+	return opts, err
+}